@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// commands are the administrative subcommands; without one, the service runs.
+var commands = map[string]func(args []string) int{
+	"migrate":  migrateCommand,
+	"resolve":  resolveCommand,
+	"sign":     signCommand,
+	"store":    storeCommand,
+	"validate": validateCommand,
+}
+
+// runCommand runs the named subcommand and returns its exit code.
+func runCommand(name string, args []string) int {
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected one of: %v\n", name, slices.Sorted(maps.Keys(commands)))
+		return 2
+	}
+	return command(args)
+}
@@ -8,9 +8,11 @@ import (
 	"sync"
 
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/gin-gonic/gin"
 	ginmiddleware "github.com/oapi-codegen/gin-middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -21,6 +23,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
@@ -50,6 +56,37 @@ func main() {
 
 	// Create handler (shared between HTTP and gRPC)
 	authzHandler := server.NewServerHandler(publicURL, swagger)
+	if err := authzHandler.RegisterMetrics(otel.Meter("ext-authz-router")); err != nil {
+		log.Printf("E: failed to register configuration metrics: %v", err)
+	}
+
+	// Apply configuration changes (and SIGHUP) without restarting
+	go func() {
+		if err := authzHandler.WatchConfig(context.Background()); err != nil {
+			log.Printf("E: configuration watcher failed: %v", err)
+		}
+	}()
+
+	// Purge expired namespaces managed through the admin API
+	go func() {
+		if err := authzHandler.RunJanitor(context.Background()); err != nil {
+			log.Printf("E: janitor failed: %v", err)
+		}
+	}()
+
+	// Merge namespaces discovered from Kubernetes objects
+	go func() {
+		if err := authzHandler.RunDiscovery(context.Background()); err != nil {
+			log.Printf("E: Kubernetes discovery failed: %v", err)
+		}
+	}()
+
+	// Deliver events, such as configuration diffs, to outbound webhooks
+	go func() {
+		if err := authzHandler.RunWebhooks(context.Background()); err != nil {
+			log.Printf("E: outbound webhooks failed: %v", err)
+		}
+	}()
 
 	var wg sync.WaitGroup
 
@@ -68,9 +105,14 @@ func main() {
 		// All routes registered from this point will have tracing
 
 		// Add custom non-OpenAPI routes before validation
-		r.Use(ginmiddleware.OapiRequestValidator(swagger))
+		r.Use(ginmiddleware.OapiRequestValidatorWithOptions(swagger, &ginmiddleware.Options{
+			Options: openapi3filter.Options{
+				// admin tokens are checked by AdminAuthMiddleware
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}))
 		// All routes registered from this point will be enforced against the OpenAPI spec
-		handler := api.NewStrictHandler(authzHandler, nil)
+		handler := api.NewStrictHandler(authzHandler, []api.StrictMiddlewareFunc{authzHandler.AdminAuthMiddleware})
 		api.RegisterHandlers(r, handler)
 
 		log.Printf("Starting HTTP server on :%s", port)
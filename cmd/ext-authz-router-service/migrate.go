@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelw/ext-authz-router/internal/server"
+)
+
+// migrateCommand upgrades configuration files to the current format
+// version, printing the result or rewriting the files in place.
+func migrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	write := flags.Bool("w", false, "rewrite files in place instead of printing them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s migrate [-w] FILE...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var changed bool
+			data, changed, err = server.MigrateConfig(data)
+			switch {
+			case err != nil:
+			case !*write:
+				_, err = os.Stdout.Write(data)
+			case changed:
+				if err = replaceFile(path, data); err == nil {
+					fmt.Fprintf(os.Stderr, "%s: upgraded to %s\n", path, server.CONFIG_API_VERSION)
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "%s: already at %s\n", path, server.CONFIG_API_VERSION)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// replaceFile atomically replaces the contents of the file at path, keeping
+// its mode, as configuration files holding secrets may not be world
+// readable.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/michaelw/ext-authz-router/internal/server"
+)
+
+// resolveCommand prints the effective configuration: namespaces with their
+// defaults and templates applied, and server settings with environment
+// overrides and defaults applied.  Each namespace is annotated with the
+// file it was defined in.
+func resolveCommand(args []string) int {
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s resolve PATH\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	cfg, warnings, err := server.LoadConfig(path)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %v\n", path, warning)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+		return 1
+	}
+
+	// Inheritance has been applied to the namespaces
+	cfg.Defaults = nil
+	cfg.Templates = nil
+
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "namespaces" {
+			continue
+		}
+		namespaces := node.Content[i+1]
+		for j := 0; j+1 < len(namespaces.Content); j += 2 {
+			key := namespaces.Content[j]
+			key.LineComment = "from " + cfg.Namespaces[key.Value].Source
+		}
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelw/ext-authz-router/internal/server"
+)
+
+// signCommand writes a detached signature next to each configuration file,
+// or into each directory of fragments for their manifest, for services
+// started with CONFIG_TRUSTED_KEYS.
+func signCommand(args []string) int {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyPath := flags.String("key", "", "PEM-encoded ed25519 private key (openssl genpkey -algorithm ed25519)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s sign -key KEY FILE|DIR...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *keyPath == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	key, err := server.ParsePrivateKey(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: %v\n", *keyPath, err)
+		return 1
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		data, signaturePath, err := signedData(path)
+		if err == nil {
+			err = os.WriteFile(signaturePath, server.SignConfig(data, key), 0644)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "%s: signed, wrote %s\n", path, signaturePath)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// signedData returns what is signed for the configuration at path and
+// where its signature goes.
+func signedData(path string) ([]byte, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		data, err := server.ConfigManifest(path)
+		return data, filepath.Join(path, server.CONFIG_MANIFEST_SIGNATURE), err
+	}
+	data, err := os.ReadFile(path)
+	return data, path + server.CONFIG_SIGNATURE_SUFFIX, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/michaelw/ext-authz-router/internal/server"
+)
+
+// storeCommand imports namespaces from configuration files into the
+// namespace store of the admin API, or exports the stored namespaces in the
+// configuration format.
+func storeCommand(args []string) int {
+	flags := flag.NewFlagSet("store", flag.ContinueOnError)
+	path := flags.String("db", os.Getenv("ADMIN_STORE_PATH"), "namespace store, a SQLite database (.db) or a YAML file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s store [-db PATH] import FILE...\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "       %s store [-db PATH] export\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	store, err := server.OpenNamespaceStore(ctx, *path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	switch flags.Arg(0) {
+	case "import":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 2
+		}
+		return importNamespaces(ctx, store, flags.Args()[1:])
+	case "export":
+		namespaces, err := store.Load(ctx)
+		if err == nil {
+			var data []byte
+			if data, err = server.MarshalNamespaces(namespaces); err == nil {
+				_, err = os.Stdout.Write(data)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	flags.Usage()
+	return 2
+}
+
+// importNamespaces validates configuration files and adds or replaces their
+// namespaces, with inheritance applied, in the store.
+func importNamespaces(ctx context.Context, store server.NamespaceStore, paths []string) int {
+	status := 0
+	for _, path := range paths {
+		cfg, warnings, err := server.LoadConfig(path)
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "%s: warning: %v\n", path, warning)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+			status = 1
+			continue
+		}
+		for id, ns := range cfg.Namespaces {
+			ns.Extends, ns.Source = "", ""
+			if err := store.Put(ctx, id, ns); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error: %s: %v\n", path, id, err)
+				status = 1
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: imported %d namespaces\n", path, len(cfg.Namespaces))
+	}
+	return status
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/michaelw/ext-authz-router/internal/server"
+)
+
+// validateCommand checks configuration files and exits non-zero if any of
+// them is invalid, so that CI can gate config changes before they deploy.
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "treat warnings as errors")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s validate [-strict] FILE...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		_, warnings, err := server.LoadConfig(path)
+		for _, warning := range warnings {
+			fmt.Printf("%s: warning: %v\n", path, warning)
+		}
+		switch {
+		case err != nil:
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("%s: error: %s\n", path, line)
+			}
+			status = 1
+		case *strict && len(warnings) > 0:
+			status = 1
+		default:
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	return status
+}
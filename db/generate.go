@@ -1,3 +1,5 @@
+// Package db holds the SQLite schema and the queries of the namespace
+// store.  The query code is generated by sqlc from queries.sql.
 package db
 
-// placeholder for generating database-related code.
+//go:generate go tool sqlc generate
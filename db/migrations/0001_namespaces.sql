@@ -0,0 +1,8 @@
+-- Namespaces managed through the admin API
+CREATE TABLE namespaces (
+    id          TEXT PRIMARY KEY NOT NULL,
+    target      TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- Ephemeral namespaces
+ALTER TABLE namespaces ADD COLUMN expires_at TIMESTAMP;
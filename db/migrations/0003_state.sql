@@ -0,0 +1,2 @@
+-- Namespace lifecycle states
+ALTER TABLE namespaces ADD COLUMN state TEXT NOT NULL DEFAULT '';
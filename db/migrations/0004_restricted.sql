@@ -0,0 +1,2 @@
+-- Restricted namespaces
+ALTER TABLE namespaces ADD COLUMN restricted BOOLEAN NOT NULL DEFAULT FALSE;
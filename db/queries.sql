@@ -0,0 +1,22 @@
+-- name: GetNamespace :one
+SELECT * FROM namespaces
+WHERE id = ?;
+
+-- name: ListNamespaces :many
+SELECT * FROM namespaces
+ORDER BY id;
+
+-- name: UpsertNamespace :exec
+INSERT INTO namespaces (id, target, description, expires_at, state, restricted)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    target = excluded.target,
+    description = excluded.description,
+    expires_at = excluded.expires_at,
+    state = excluded.state,
+    restricted = excluded.restricted,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteNamespace :exec
+DELETE FROM namespaces
+WHERE id = ?;
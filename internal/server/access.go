@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+const (
+	ACCESS_GRANT_DURATION     = 8 * time.Hour
+	ACCESS_GRANT_MAX_DURATION = 30 * 24 * time.Hour
+	// ACCESS_REQUEST_RETENTION is how long pending and denied access
+	// requests are kept.
+	ACCESS_REQUEST_RETENTION = 7 * 24 * time.Hour
+	ACCESS_MAX_REQUESTER     = 64
+	ACCESS_MAX_REASON        = 500
+
+	EVENT_ACCESS_REQUESTED = "access.requested"
+	EVENT_ACCESS_EXPIRED   = "access.expired"
+)
+
+// accessRequest is a request of a client for access to a restricted
+// namespace of a catalog.  Once approved, it is the client's grant.
+type accessRequest struct {
+	api.AccessRequest
+	clientID string
+	catalog  string
+}
+
+// active reports whether the request is pending or an unexpired grant.
+func (r *accessRequest) active(now time.Time) bool {
+	switch r.Status {
+	case api.Pending:
+		return true
+	case api.Approved:
+		return now.Before(*r.ExpiresAt)
+	}
+	return false
+}
+
+// access returns the latest access request of client for a namespace of a
+// catalog, preferring an active one.
+func (h *AuthzHandler) access(catalog, namespace, client string, now time.Time) (api.AccessRequest, bool) {
+	if client == "" {
+		return api.AccessRequest{}, false
+	}
+	h.accessLock.Lock()
+	defer h.accessLock.Unlock()
+	var latest *accessRequest
+	for _, r := range h.accessRequests {
+		if r.Namespace != namespace || r.catalog != catalog || r.clientID != client {
+			continue
+		}
+		if r.Status == api.Approved && !r.active(now) {
+			continue
+		}
+		if latest == nil ||
+			(r.active(now) && !latest.active(now)) ||
+			(r.active(now) == latest.active(now) && r.CreatedAt.After(latest.CreatedAt)) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return api.AccessRequest{}, false
+	}
+	return latest.AccessRequest, true
+}
+
+// granted reports whether client has an unexpired grant for a namespace of
+// a catalog.
+func (h *AuthzHandler) granted(catalog, namespace, client string, now time.Time) bool {
+	r, ok := h.access(catalog, namespace, client, now)
+	return ok && r.Status == api.Approved
+}
+
+// CreateAccessRequest handles POST /namespaces/{id}/access-requests - Request
+// access to a namespace of the catalog for redirect_to
+func (h *AuthzHandler) CreateAccessRequest(ctx context.Context, request api.CreateAccessRequestRequestObject) (api.CreateAccessRequestResponseObject, error) {
+	requester := strings.TrimSpace(request.Body.Requester)
+	if requester == "" || len(requester) > ACCESS_MAX_REQUESTER {
+		return api.CreateAccessRequest400JSONResponse(errorResponse("bad_request", fmt.Sprintf("requester must be 1 to %d characters", ACCESS_MAX_REQUESTER))), nil
+	}
+	var reason *string
+	if request.Body.Reason != nil && strings.TrimSpace(*request.Body.Reason) != "" {
+		if len(*request.Body.Reason) > ACCESS_MAX_REASON {
+			return api.CreateAccessRequest400JSONResponse(errorResponse("bad_request", fmt.Sprintf("reason must be at most %d characters", ACCESS_MAX_REASON))), nil
+		}
+		trimmed := strings.TrimSpace(*request.Body.Reason)
+		reason = &trimmed
+	}
+
+	cfg := h.config().forRedirect(request.Params.RedirectTo, requestHost(ctx))
+	now := time.Now()
+	ns, ok := h.namespaceOrPattern(ctx, cfg, request.Id)
+	if !ok || ns.expired(now) || !ns.selectable() {
+		return api.CreateAccessRequest404JSONResponse{NotFoundJSONResponse: notFound(request.Id)}, nil
+	}
+	if !ns.Restricted {
+		return api.CreateAccessRequest400JSONResponse(errorResponse("bad_request", "Namespace "+request.Id+" is not restricted")), nil
+	}
+	client := h.clientID(ctx, cfg, true)
+	if client == "" {
+		return api.CreateAccessRequest400JSONResponse(errorResponse("bad_request", "no client identity")), nil
+	}
+	if existing, ok := h.access(cfg.catalog, request.Id, client, now); ok && existing.Status != api.Denied {
+		return api.CreateAccessRequest202JSONResponse(existing), nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	r := &accessRequest{
+		AccessRequest: api.AccessRequest{
+			Id:        hex.EncodeToString(b),
+			Namespace: request.Id,
+			Requester: requester,
+			Reason:    reason,
+			Status:    api.Pending,
+			CreatedAt: now.UTC().Truncate(time.Second),
+		},
+		clientID: client,
+		catalog:  cfg.catalog,
+	}
+	if cfg.catalog != "" {
+		r.Catalog = &cfg.catalog
+	}
+	h.accessLock.Lock()
+	if h.accessRequests == nil {
+		h.accessRequests = map[string]*accessRequest{}
+	}
+	h.accessRequests[r.Id] = r
+	h.accessLock.Unlock()
+
+	h.emit(Event{
+		Type:      EVENT_ACCESS_REQUESTED,
+		Namespace: request.Id,
+		Message:   fmt.Sprintf("%s requests access to namespace %s (request %s)", requester, catalogNamespace{cfg.catalog, request.Id}, r.Id),
+	})
+	return api.CreateAccessRequest202JSONResponse(r.AccessRequest), nil
+}
+
+// ListAccessRequests handles GET /admin/access-requests
+func (h *AuthzHandler) ListAccessRequests(ctx context.Context, request api.ListAccessRequestsRequestObject) (api.ListAccessRequestsResponseObject, error) {
+	h.accessLock.Lock()
+	defer h.accessLock.Unlock()
+	requests := []api.AccessRequest{}
+	for _, r := range h.accessRequests {
+		requests = append(requests, r.AccessRequest)
+	}
+	slices.SortFunc(requests, func(a, b api.AccessRequest) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+	return api.ListAccessRequests200JSONResponse{Requests: requests}, nil
+}
+
+// ApproveAccessRequest handles POST /admin/access-requests/{id}/approve
+func (h *AuthzHandler) ApproveAccessRequest(ctx context.Context, request api.ApproveAccessRequestRequestObject) (api.ApproveAccessRequestResponseObject, error) {
+	duration := ACCESS_GRANT_DURATION
+	if request.Body.Duration != nil {
+		d, err := time.ParseDuration(*request.Body.Duration)
+		if err != nil || d <= 0 || d > ACCESS_GRANT_MAX_DURATION {
+			return api.ApproveAccessRequest400JSONResponse(errorResponse("bad_request", fmt.Sprintf("invalid duration %q, must be positive and at most %v", *request.Body.Duration, ACCESS_GRANT_MAX_DURATION))), nil
+		}
+		duration = d
+	}
+
+	h.accessLock.Lock()
+	r, ok := h.accessRequests[request.Id]
+	if !ok {
+		h.accessLock.Unlock()
+		return api.ApproveAccessRequest404JSONResponse{NotFoundJSONResponse: accessRequestNotFound(request.Id)}, nil
+	}
+	if r.Status == api.Denied {
+		h.accessLock.Unlock()
+		return api.ApproveAccessRequest409JSONResponse{ConflictJSONResponse: api.ConflictJSONResponse(errorResponse("conflict", "Access request "+request.Id+" was denied"))}, nil
+	}
+	actor := auditActor(ctx)
+	expiresAt := time.Now().Add(duration).UTC().Truncate(time.Second)
+	r.Status, r.DecidedBy, r.ExpiresAt = api.Approved, &actor, &expiresAt
+	approved := r.AccessRequest
+	h.accessLock.Unlock()
+
+	h.audit(ctx, AuditRecord{Action: "approve", Namespace: approved.Namespace, AccessRequest: approved.Id, Requester: approved.Requester})
+	return api.ApproveAccessRequest200JSONResponse(approved), nil
+}
+
+// DenyAccessRequest handles POST /admin/access-requests/{id}/deny
+func (h *AuthzHandler) DenyAccessRequest(ctx context.Context, request api.DenyAccessRequestRequestObject) (api.DenyAccessRequestResponseObject, error) {
+	h.accessLock.Lock()
+	r, ok := h.accessRequests[request.Id]
+	if !ok {
+		h.accessLock.Unlock()
+		return api.DenyAccessRequest404JSONResponse{NotFoundJSONResponse: accessRequestNotFound(request.Id)}, nil
+	}
+	actor := auditActor(ctx)
+	r.Status, r.DecidedBy, r.ExpiresAt = api.Denied, &actor, nil
+	denied := r.AccessRequest
+	h.accessLock.Unlock()
+
+	h.audit(ctx, AuditRecord{Action: "deny", Namespace: denied.Namespace, AccessRequest: denied.Id, Requester: denied.Requester})
+	return api.DenyAccessRequest200JSONResponse(denied), nil
+}
+
+// purgeAccessRequests deletes the grants that have expired at now, and the
+// pending and denied requests older than ACCESS_REQUEST_RETENTION, and
+// returns their IDs.
+func (h *AuthzHandler) purgeAccessRequests(now time.Time) []string {
+	h.accessLock.Lock()
+	var purged []string
+	var expired []api.AccessRequest
+	for _, id := range slices.Sorted(maps.Keys(h.accessRequests)) {
+		r := h.accessRequests[id]
+		switch {
+		case r.Status == api.Approved && !r.active(now):
+			expired = append(expired, r.AccessRequest)
+		case r.Status != api.Approved && !now.Before(r.CreatedAt.Add(ACCESS_REQUEST_RETENTION)):
+		default:
+			continue
+		}
+		purged = append(purged, id)
+		delete(h.accessRequests, id)
+	}
+	h.accessLock.Unlock()
+
+	for _, r := range expired {
+		h.emit(Event{
+			Type:      EVENT_ACCESS_EXPIRED,
+			Namespace: r.Namespace,
+			Message:   fmt.Sprintf("access of %s to namespace %s expired at %s (request %s)", r.Requester, r.Namespace, r.ExpiresAt.Format(time.RFC3339), r.Id),
+		})
+	}
+	return purged
+}
+
+func accessRequestNotFound(id string) api.NotFoundJSONResponse {
+	return api.NotFoundJSONResponse(errorResponse("not_found", "Unknown access request "+id))
+}
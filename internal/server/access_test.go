@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+func TestAccessRequests(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+  staging:
+    target: staging
+    restricted: true
+`
+
+	setup := func(t *testing.T) (*AuthzHandler, *client, *client) {
+		t.Helper()
+		handler := newTestHandler(t, config)
+		router := newAdminRouter(t, handler)
+		return handler, &client{router: router}, &client{router: router}
+	}
+
+	requestAccess := func(t *testing.T, c *client) api.AccessRequest {
+		t.Helper()
+		rec := c.do(http.MethodPost, "/namespaces/staging/access-requests", `{"requester": "alice", "reason": "OPS-123"}`)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+		var r api.AccessRequest
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid response %s: %v", rec.Body, err)
+		}
+		return r
+	}
+
+	check := func(handler *AuthzHandler, c *client) codes.Code {
+		headers := map[string]string{"x-namespace": "staging"}
+		if cookie := c.cookies["namespace"+CLIENT_COOKIE_SUFFIX]; cookie != nil {
+			headers["cookie"] = cookie.Name + "=" + cookie.Value
+		}
+		resp, _ := NewAuthzGRPCServer(handler).Check(context.Background(), newCheckRequest("envdemo.test", "/", headers))
+		return codes.Code(resp.GetStatus().GetCode())
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		handler, alice, bob := setup(t)
+		var events []Event
+		WithEventListener(func(e Event) { events = append(events, e) })(handler)
+
+		if rec := alice.do(http.MethodPost, "/submit", `{"value": "staging"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 before access is requested, got %d: %s", rec.Code, rec.Body)
+		}
+		r := requestAccess(t, alice)
+		if r.Status != api.Pending || r.Requester != "alice" {
+			t.Errorf("Expected pending request, got %+v", r)
+		}
+		if again := requestAccess(t, alice); again.Id != r.Id {
+			t.Errorf("Expected the pending request to be returned, got %s", again.Id)
+		}
+		if len(events) != 1 || events[0].Type != EVENT_ACCESS_REQUESTED {
+			t.Errorf("Expected an access.requested event, got %+v", events)
+		}
+		if code := check(handler, alice); code != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied while pending, got %v", code)
+		}
+
+		rec := adminRequest(alice.router, http.MethodGet, "/admin/access-requests", "secret", "")
+		if !strings.Contains(rec.Body.String(), r.Id) {
+			t.Errorf("Expected request %s to be listed, got %s", r.Id, rec.Body)
+		}
+		if rec := adminRequest(alice.router, http.MethodPost, "/admin/access-requests/"+r.Id+"/approve", "", `{"duration": "1h"}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 without token, got %d", rec.Code)
+		}
+		rec = adminRequest(alice.router, http.MethodPost, "/admin/access-requests/"+r.Id+"/approve", "secret", `{"duration": "1h"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var approved api.AccessRequest
+		json.Unmarshal(rec.Body.Bytes(), &approved)
+		if approved.Status != api.Approved || approved.DecidedBy == nil || *approved.DecidedBy != "ci" || approved.ExpiresAt == nil {
+			t.Errorf("Unexpected approved request: %s", rec.Body)
+		}
+
+		if code := check(handler, alice); code != codes.OK {
+			t.Errorf("Expected OK with a grant, got %v", code)
+		}
+		if code := check(handler, bob); code != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied for another client, got %v", code)
+		}
+		if rec := alice.do(http.MethodPost, "/submit", `{"value": "staging"}`); rec.Code != http.StatusFound {
+			t.Errorf("Expected 302 with a grant, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := bob.do(http.MethodPost, "/submit", `{"value": "staging"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for another client, got %d: %s", rec.Code, rec.Body)
+		}
+
+		if !handler.granted("", "staging", alice.cookies["namespace"+CLIENT_COOKIE_SUFFIX].Value, time.Now()) {
+			t.Fatal("Expected a grant")
+		}
+		if purged := handler.purgeAccessRequests(time.Now().Add(2 * time.Hour)); strings.Join(purged, ",") != r.Id {
+			t.Errorf("Expected the expired grant to be purged, got %v", purged)
+		}
+		if code := check(handler, alice); code != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied after expiry, got %v", code)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		handler, alice, _ := setup(t)
+		r := requestAccess(t, alice)
+
+		if rec := adminRequest(alice.router, http.MethodPost, "/admin/access-requests/"+r.Id+"/deny", "secret", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := adminRequest(alice.router, http.MethodPost, "/admin/access-requests/"+r.Id+"/approve", "secret", "{}"); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for a denied request, got %d", rec.Code)
+		}
+		if code := check(handler, alice); code != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied, got %v", code)
+		}
+		// a new request can be filed
+		if again := requestAccess(t, alice); again.Id == r.Id || again.Status != api.Pending {
+			t.Errorf("Expected a new pending request, got %+v", again)
+		}
+	})
+
+	t.Run("selector", func(t *testing.T) {
+		_, alice, _ := setup(t)
+		requestAccess(t, alice)
+
+		rec := alice.do(http.MethodGet, "/namespaces", "")
+		var list api.NamespaceList
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		staging := list.Namespaces["staging"]
+		if staging.Restricted == nil || !*staging.Restricted || staging.Access == nil || staging.Access.Status != api.Pending {
+			t.Errorf("Expected restricted namespace with pending access, got %s", rec.Body)
+		}
+		if list.Namespaces["cool-otter"].Restricted != nil {
+			t.Errorf("Expected cool-otter not to be restricted, got %s", rec.Body)
+		}
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		_, alice, _ := setup(t)
+
+		type testCase struct {
+			method, path, body string
+			expected           int
+		}
+		tests := []testCase{
+			{http.MethodPost, "/namespaces/staging/access-requests", `{"requester": ""}`, http.StatusBadRequest},
+			{http.MethodPost, "/namespaces/cool-otter/access-requests", `{"requester": "alice"}`, http.StatusBadRequest},
+			{http.MethodPost, "/namespaces/missing/access-requests", `{"requester": "alice"}`, http.StatusNotFound},
+		}
+		for _, tc := range tests {
+			if rec := alice.do(tc.method, tc.path, tc.body); rec.Code != tc.expected {
+				t.Errorf("%s %s: expected %d, got %d: %s", tc.path, tc.body, tc.expected, rec.Code, rec.Body)
+			}
+		}
+		r := requestAccess(t, alice)
+		if rec := adminRequest(alice.router, http.MethodPost, "/admin/access-requests/"+r.Id+"/approve", "secret", `{"duration": "90d"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a too long grant, got %d", rec.Code)
+		}
+		if rec := adminRequest(alice.router, http.MethodPost, "/admin/access-requests/missing/deny", "secret", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+}
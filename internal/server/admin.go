@@ -0,0 +1,451 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michaelw/ext-authz-router/api"
+	strictgin "github.com/oapi-codegen/runtime/strictmiddleware/gin"
+)
+
+// ADMIN_ACTOR_KEY is the gin context key holding the name of the token an
+// admin request was authorized with.
+const ADMIN_ACTOR_KEY = "ext-authz-router/admin-actor"
+
+// adminOperations are the operations that require an admin token.
+var adminOperations = map[string]bool{
+	"ListAdminNamespaces":  true,
+	"GetAdminNamespace":    true,
+	"PutAdminNamespace":    true,
+	"DeleteAdminNamespace": true,
+
+	"CreateAdminPromotion":   true,
+	"ConfirmAdminPromotion":  true,
+	"RollbackAdminPromotion": true,
+
+	"ListAccessRequests":   true,
+	"ApproveAccessRequest": true,
+	"DenyAccessRequest":    true,
+}
+
+// WithAdminTokens enables the admin API with the bearer tokens in the file
+// at path.  Each line holds a name, used in audit records, and a token,
+// separated by a colon; empty lines and lines starting with # are ignored.
+// The file is read on every request, so tokens can be rotated without a
+// restart.
+func WithAdminTokens(path string) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.adminTokensPath = path
+	}
+}
+
+// WithAuditLog writes an audit record, one JSON object per line, to w for
+// every change made through the admin API.
+func WithAuditLog(w io.Writer) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.auditLog = w
+	}
+}
+
+// AuditRecord describes a change made through the admin API.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Client    string    `json:"client,omitempty"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	// Promotion is the ID of the promotion the change is part of, and From
+	// the namespace whose target was promoted.
+	Promotion string `json:"promotion,omitempty"`
+	From      string `json:"from,omitempty"`
+	// AccessRequest is the ID of the access request that was decided, and
+	// Requester who filed it.
+	AccessRequest string           `json:"accessRequest,omitempty"`
+	Requester     string           `json:"requester,omitempty"`
+	Before        *NamespaceConfig `json:"before,omitempty"`
+	After         *NamespaceConfig `json:"after,omitempty"`
+}
+
+// readAdminTokens reads the token file, returning a map of tokens to names.
+func readAdminTokens(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(text, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME:TOKEN", path, line)
+		}
+		tokens[token] = name
+	}
+	return tokens, scanner.Err()
+}
+
+// authenticateAdmin returns the name of the token in an Authorization
+// header, or an error describing why it is not accepted.
+func (h *AuthzHandler) authenticateAdmin(authorization string) (string, error) {
+	if h.adminTokensPath == "" {
+		return "", errors.New("the admin API is not enabled")
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	tokens, err := readAdminTokens(h.adminTokensPath)
+	if err != nil {
+		log.Printf("E: [admin] failed to read tokens: %v", err)
+		return "", errors.New("invalid bearer token")
+	}
+	// compare against every token, so that timing does not reveal which
+	// one was close
+	var name string
+	for candidate, candidateName := range tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			name = candidateName
+		}
+	}
+	if name == "" {
+		return "", errors.New("invalid bearer token")
+	}
+	return name, nil
+}
+
+// AdminAuthMiddleware is a strict server middleware that requires a valid
+// bearer token for the admin operations.
+func (h *AuthzHandler) AdminAuthMiddleware(f strictgin.StrictGinHandlerFunc, operationID string) strictgin.StrictGinHandlerFunc {
+	if !adminOperations[operationID] {
+		return f
+	}
+	return func(ctx *gin.Context, request interface{}) (interface{}, error) {
+		actor, err := h.authenticateAdmin(ctx.GetHeader("Authorization"))
+		if err != nil {
+			ctx.Header("WWW-Authenticate", `Bearer realm="ext-authz-router-admin"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse("unauthorized", err.Error()))
+			return nil, nil
+		}
+		ctx.Set(ADMIN_ACTOR_KEY, actor)
+		return f(ctx, request)
+	}
+}
+
+// RequireAdmin is a gin middleware that requires a valid admin bearer
+// token, for the routes outside the OpenAPI specification.
+func (h *AuthzHandler) RequireAdmin(c *gin.Context) {
+	actor, err := h.authenticateAdmin(c.GetHeader("Authorization"))
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="ext-authz-router-admin"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse("unauthorized", err.Error()))
+		return
+	}
+	c.Set(ADMIN_ACTOR_KEY, actor)
+	c.Next()
+}
+
+// ListAdminNamespaces handles GET /admin/namespaces
+func (h *AuthzHandler) ListAdminNamespaces(ctx context.Context, request api.ListAdminNamespacesRequestObject) (api.ListAdminNamespacesResponseObject, error) {
+	namespaces := map[string]api.AdminNamespace{}
+	for id, ns := range h.namespaces(ctx, h.config()) {
+		namespaces[id] = adminNamespace(ns)
+	}
+	return api.ListAdminNamespaces200JSONResponse{Namespaces: namespaces}, nil
+}
+
+// GetAdminNamespace handles GET /admin/namespaces/{id}
+func (h *AuthzHandler) GetAdminNamespace(ctx context.Context, request api.GetAdminNamespaceRequestObject) (api.GetAdminNamespaceResponseObject, error) {
+	ns, ok := h.namespace(ctx, h.config(), request.Id)
+	if !ok {
+		return api.GetAdminNamespace404JSONResponse{NotFoundJSONResponse: notFound(request.Id)}, nil
+	}
+	return api.GetAdminNamespace200JSONResponse(adminNamespace(ns)), nil
+}
+
+// PutAdminNamespace handles PUT /admin/namespaces/{id} - Create or update a
+// namespace managed through the admin API
+func (h *AuthzHandler) PutAdminNamespace(ctx context.Context, request api.PutAdminNamespaceRequestObject) (api.PutAdminNamespaceResponseObject, error) {
+	ns := NamespaceConfig{
+		Target: request.Body.Target,
+		Source: NAMESPACE_SOURCE_ADMIN,
+	}
+	if request.Body.Description != nil {
+		ns.Description = *request.Body.Description
+	}
+	expiresAt, err := requestedExpiry(request.Body.ExpiresAt, request.Body.Ttl, time.Now())
+	if err != nil {
+		return api.PutAdminNamespace400JSONResponse(errorResponse("bad_request", err.Error())), nil
+	}
+	ns.ExpiresAt = expiresAt
+	if request.Body.State != nil && *request.Body.State != NAMESPACE_STATE_ACTIVE {
+		ns.State = string(*request.Body.State)
+	}
+	ns.Restricted = request.Body.Restricted != nil && *request.Body.Restricted
+
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	cfg := h.config()
+	previous, exists := h.namespace(ctx, cfg, request.Id)
+	if exists && previous.Source != NAMESPACE_SOURCE_ADMIN {
+		return api.PutAdminNamespace409JSONResponse{ConflictJSONResponse: conflict(request.Id, previous.Source)}, nil
+	}
+
+	if err := validateManagedNamespace(cfg, request.Id, ns); err != nil {
+		return api.PutAdminNamespace400JSONResponse(errorResponse("bad_request", err.Error())), nil
+	}
+
+	if err := h.setManagedNamespace(ctx, request.Id, &ns); err != nil {
+		return nil, err
+	}
+	record := AuditRecord{Action: "create", Namespace: request.Id, After: &ns}
+	if exists {
+		record.Action, record.Before = "update", &previous
+	}
+	h.audit(ctx, record)
+
+	if exists {
+		return api.PutAdminNamespace200JSONResponse(adminNamespace(ns)), nil
+	}
+	return api.PutAdminNamespace201JSONResponse(adminNamespace(ns)), nil
+}
+
+// DeleteAdminNamespace handles DELETE /admin/namespaces/{id}
+func (h *AuthzHandler) DeleteAdminNamespace(ctx context.Context, request api.DeleteAdminNamespaceRequestObject) (api.DeleteAdminNamespaceResponseObject, error) {
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	previous, exists := h.namespace(ctx, h.config(), request.Id)
+	switch {
+	case !exists:
+		return api.DeleteAdminNamespace404JSONResponse{NotFoundJSONResponse: notFound(request.Id)}, nil
+	case previous.Source != NAMESPACE_SOURCE_ADMIN:
+		return api.DeleteAdminNamespace409JSONResponse{ConflictJSONResponse: conflict(request.Id, previous.Source)}, nil
+	}
+
+	if err := h.setManagedNamespace(ctx, request.Id, nil); err != nil {
+		return nil, err
+	}
+	h.audit(ctx, AuditRecord{Action: "delete", Namespace: request.Id, Before: &previous})
+	return api.DeleteAdminNamespace204Response{}, nil
+}
+
+// validateManagedNamespace checks that cfg remains valid with ns added as id.
+func validateManagedNamespace(cfg AuthzConfig, id string, ns NamespaceConfig) error {
+	candidate := cfg
+	candidate.Namespaces = maps.Clone(cfg.Namespaces)
+	if candidate.Namespaces == nil {
+		candidate.Namespaces = map[string]NamespaceConfig{}
+	}
+	candidate.Namespaces[id] = ns
+	_, err := candidate.Validate()
+	return err
+}
+
+// setManagedNamespace persists a namespace managed through the admin API, or
+// deletes it if ns is nil, and applies the change as a new generation of the
+// current configuration.  Callers must hold reloadLock.
+func (h *AuthzHandler) setManagedNamespace(ctx context.Context, id string, ns *NamespaceConfig) error {
+	before := h.lookupNamespaces(ctx, []string{id})
+	if h.store != nil {
+		var err error
+		if ns == nil {
+			err = h.store.Delete(ctx, id)
+		} else {
+			err = h.store.Put(ctx, id, *ns)
+		}
+		if err != nil {
+			return fmt.Errorf("persisting namespace %s: %w", id, err)
+		}
+	}
+
+	h.commitManaged(map[string]*NamespaceConfig{id: ns})
+	h.emitDiff(diffNamespaces(before, h.lookupNamespaces(ctx, []string{id}), h.status().Generation))
+	return nil
+}
+
+// putManagedNamespaces persists several namespaces managed through the admin
+// API and applies them together as one new generation.  Callers must hold
+// reloadLock.
+func (h *AuthzHandler) putManagedNamespaces(ctx context.Context, namespaces map[string]NamespaceConfig) error {
+	ids := slices.Sorted(maps.Keys(namespaces))
+	before := h.lookupNamespaces(ctx, ids)
+	if h.store != nil {
+		if err := h.store.PutAll(ctx, namespaces); err != nil {
+			return fmt.Errorf("persisting namespaces %s: %w", strings.Join(ids, ", "), err)
+		}
+	}
+
+	changes := map[string]*NamespaceConfig{}
+	for id, ns := range namespaces {
+		changes[id] = &ns
+	}
+	h.commitManaged(changes)
+	h.emitDiff(diffNamespaces(before, h.lookupNamespaces(ctx, ids), h.status().Generation))
+	return nil
+}
+
+// commitManaged applies persisted changes to namespaces managed through the
+// admin API as a new generation of the current configuration.
+func (h *AuthzHandler) commitManaged(changes map[string]*NamespaceConfig) {
+	h.configLock.Lock()
+	defer h.configLock.Unlock()
+	if _, ok := h.store.(NamespaceReader); !ok {
+		for id, ns := range changes {
+			h.updateManaged(id, ns)
+		}
+	}
+	h.currentConfig.status.Generation++
+	h.currentConfig.status.LoadedAt = time.Now()
+	ids := slices.Sorted(maps.Keys(changes))
+	log.Printf("[config] namespace %s changed through the admin API (generation %d)", strings.Join(ids, ", "), h.currentConfig.status.Generation)
+}
+
+// updateManaged applies a change to the namespaces managed through the admin
+// API that are held in memory.  Callers must hold configLock.
+func (h *AuthzHandler) updateManaged(id string, ns *NamespaceConfig) {
+	managed := maps.Clone(h.managed)
+	namespaces := maps.Clone(h.currentConfig.Namespaces)
+	if managed == nil {
+		managed = map[string]NamespaceConfig{}
+	}
+	if namespaces == nil {
+		namespaces = map[string]NamespaceConfig{}
+	}
+	if ns == nil {
+		delete(managed, id)
+		delete(namespaces, id)
+		if discovered, ok := h.discovered[id]; ok {
+			namespaces[id] = discovered
+		}
+	} else {
+		managed[id] = *ns
+		namespaces[id] = *ns
+	}
+	h.managed = managed
+	h.currentConfig.Namespaces = namespaces
+}
+
+// loadManagedNamespaces reads the namespaces managed through the admin API
+// from the store, unless they are read from the store directly.
+func (h *AuthzHandler) loadManagedNamespaces() error {
+	if _, ok := h.store.(NamespaceReader); h.store == nil || ok {
+		return nil
+	}
+	managed, err := h.store.Load(context.Background())
+	if err != nil {
+		return err
+	}
+	for id, ns := range managed {
+		ns.Source = NAMESPACE_SOURCE_ADMIN
+		managed[id] = ns
+	}
+	h.configLock.Lock()
+	defer h.configLock.Unlock()
+	h.managed = managed
+	return nil
+}
+
+// withManagedNamespaces adds the namespaces managed through the admin API to
+// a configuration loaded from its source.  Namespaces in the configuration
+// take precedence.  Callers must hold configLock.
+func (h *AuthzHandler) withManagedNamespaces(cfg AuthzConfig) AuthzConfig {
+	if len(h.managed) == 0 {
+		return cfg
+	}
+	namespaces := maps.Clone(cfg.Namespaces)
+	if namespaces == nil {
+		namespaces = map[string]NamespaceConfig{}
+	}
+	for id, ns := range h.managed {
+		if static, ok := namespaces[id]; ok {
+			log.Printf("W: [config] namespace %s from the admin API is shadowed by %s", id, static.Source)
+			continue
+		}
+		namespaces[id] = ns
+	}
+	cfg.Namespaces = namespaces
+	return cfg
+}
+
+// auditActor returns the name of the token an admin request was authorized
+// with.
+func auditActor(ctx context.Context) string {
+	if actor, ok := ctx.Value(ADMIN_ACTOR_KEY).(string); ok {
+		return actor
+	}
+	return "unknown"
+}
+
+// audit records a change made through the admin API.
+func (h *AuthzHandler) audit(ctx context.Context, record AuditRecord) {
+	record.Time = time.Now().UTC()
+	if record.Actor == "" {
+		record.Actor = auditActor(ctx)
+	}
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		record.Client = c.ClientIP()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("E: [audit] %v", err)
+		return
+	}
+	log.Printf("I: [audit] %s", data)
+	if h.auditLog != nil {
+		if _, err := h.auditLog.Write(append(data, '\n')); err != nil {
+			log.Printf("E: [audit] failed to write audit log: %v", err)
+		}
+	}
+}
+
+func adminNamespace(ns NamespaceConfig) api.AdminNamespace {
+	resp := api.AdminNamespace{
+		Target: ns.Target,
+		Source: ns.Source,
+	}
+	if ns.Description != "" {
+		resp.Description = &ns.Description
+	}
+	resp.ExpiresAt = ns.ExpiresAt
+	if ns.State != "" {
+		state := api.NamespaceState(ns.State)
+		resp.State = &state
+	}
+	if ns.Restricted {
+		resp.Restricted = &ns.Restricted
+	}
+	return resp
+}
+
+func errorResponse(code, message string) api.ErrorResponse {
+	return api.ErrorResponse{Error: &code, Message: &message}
+}
+
+func notFound(id string) api.NotFoundJSONResponse {
+	return api.NotFoundJSONResponse(errorResponse("not_found", "Unknown namespace "+id))
+}
+
+func conflict(id, source string) api.ConflictJSONResponse {
+	return api.ConflictJSONResponse(errorResponse("conflict", fmt.Sprintf("Namespace %s is defined in %s", id, source)))
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+// newAdminRouter serves the API of handler, with admin tokens "ci:secret".
+func newAdminRouter(t *testing.T, handler *AuthzHandler) *gin.Engine {
+	t.Helper()
+	tokensPath := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokensPath, []byte("# admin tokens\nci:secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write tokens: %v", err)
+	}
+	WithAdminTokens(tokensPath)(handler)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.RegisterHandlers(router, api.NewStrictHandler(handler, []api.StrictMiddlewareFunc{handler.AdminAuthMiddleware}))
+	return router
+}
+
+func adminRequest(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAPI(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`
+
+	t.Run("requires a valid token", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newAdminRouter(t, handler)
+
+		for _, token := range []string{"", "wrong"} {
+			rec := adminRequest(router, http.MethodGet, "/admin/namespaces", token, "")
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for token %q, got %d", token, rec.Code)
+			}
+			if rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate header for token %q", token)
+			}
+		}
+		if rec := adminRequest(router, http.MethodGet, "/admin/namespaces", "secret", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 for valid token, got %d", rec.Code)
+		}
+		// the public endpoints stay public
+		if rec := adminRequest(router, http.MethodGet, "/namespaces", "", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 for /namespaces, got %d", rec.Code)
+		}
+	})
+
+	t.Run("disabled without tokens", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newAdminRouter(t, handler)
+		handler.adminTokensPath = ""
+		if rec := adminRequest(router, http.MethodGet, "/admin/namespaces", "secret", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("create, update and delete", func(t *testing.T) {
+		storePath := filepath.Join(t.TempDir(), "namespaces.yaml")
+		var auditLog bytes.Buffer
+		handler := newTestHandler(t, config, WithNamespaceStore(NewFileNamespaceStore(storePath)), WithAuditLog(&auditLog))
+		router := newAdminRouter(t, handler)
+
+		rec := adminRequest(router, http.MethodPut, "/admin/namespaces/pr-123", "secret", `{"target": "pr-123", "description": "PR 123"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+		}
+		ns, ok := handler.config().Namespaces["pr-123"]
+		if !ok || ns.Target != "pr-123" || ns.Source != NAMESPACE_SOURCE_ADMIN {
+			t.Errorf("Expected pr-123 to be applied, got %+v", ns)
+		}
+		if generation := handler.status().Generation; generation != 2 {
+			t.Errorf("Expected generation 2 after the change, got %d", generation)
+		}
+
+		rec = adminRequest(router, http.MethodPut, "/admin/namespaces/pr-123", "secret", `{"target": "pr-123-v2"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+
+		rec = adminRequest(router, http.MethodGet, "/admin/namespaces/pr-123", "secret", "")
+		var got api.AdminNamespace
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.Target != "pr-123-v2" {
+			t.Errorf("Expected updated target pr-123-v2, got %s", rec.Body)
+		}
+
+		// persisted in the configuration format
+		data, err := os.ReadFile(storePath)
+		if err != nil {
+			t.Fatalf("Failed to read store: %v", err)
+		}
+		if stored, err := ParseConfig(data); err != nil || stored.Namespaces["pr-123"].Target != "pr-123-v2" {
+			t.Errorf("Expected stored namespace, got %s (%v)", data, err)
+		}
+
+		if rec := adminRequest(router, http.MethodDelete, "/admin/namespaces/pr-123", "secret", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d: %s", rec.Code, rec.Body)
+		}
+		if _, ok := handler.config().Namespaces["pr-123"]; ok {
+			t.Error("Expected pr-123 to be deleted")
+		}
+		if rec := adminRequest(router, http.MethodDelete, "/admin/namespaces/pr-123", "secret", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+
+		var actions []string
+		for _, line := range strings.Split(strings.TrimSpace(auditLog.String()), "\n") {
+			var record AuditRecord
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("Invalid audit record %q: %v", line, err)
+			}
+			if record.Actor != "ci" || record.Namespace != "pr-123" {
+				t.Errorf("Unexpected audit record: %s", line)
+			}
+			actions = append(actions, record.Action)
+		}
+		if strings.Join(actions, ",") != "create,update,delete" {
+			t.Errorf("Expected create, update and delete audit records, got %v", actions)
+		}
+	})
+
+	t.Run("rejects invalid and static namespaces", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newAdminRouter(t, handler)
+
+		type testCase struct {
+			method, path, body string
+			expected           int
+		}
+		tests := []testCase{
+			{http.MethodPut, "/admin/namespaces/pr-123", `{"target": ""}`, http.StatusBadRequest},
+			{http.MethodPut, "/admin/namespaces/pr-123", `{"target": "a\u0001b"}`, http.StatusBadRequest},
+			{http.MethodPut, "/admin/namespaces/pr;123", `{"target": "x"}`, http.StatusBadRequest},
+			{http.MethodPut, "/admin/namespaces/cool-otter", `{"target": "red"}`, http.StatusConflict},
+			{http.MethodDelete, "/admin/namespaces/cool-otter", "", http.StatusConflict},
+			{http.MethodGet, "/admin/namespaces/missing", "", http.StatusNotFound},
+		}
+		for _, tc := range tests {
+			rec := adminRequest(router, tc.method, tc.path, "secret", tc.body)
+			if rec.Code != tc.expected {
+				t.Errorf("%s %s %s: expected %d, got %d: %s", tc.method, tc.path, tc.body, tc.expected, rec.Code, rec.Body)
+			}
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Errorf("Expected cool-otter to be unchanged, got %s", target)
+		}
+		if generation := handler.status().Generation; generation != 1 {
+			t.Errorf("Expected no new generation, got %d", generation)
+		}
+	})
+
+	t.Run("survives reloads and restarts", func(t *testing.T) {
+		storePath := filepath.Join(t.TempDir(), "namespaces.yaml")
+		handler := newTestHandler(t, config, WithNamespaceStore(NewFileNamespaceStore(storePath)))
+		router := newAdminRouter(t, handler)
+		if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/pr-123", "secret", `{"target": "pr-123"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+		}
+
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		if _, ok := handler.config().Namespaces["pr-123"]; !ok {
+			t.Error("Expected pr-123 to survive a reload")
+		}
+
+		restarted := newTestHandler(t, config, WithNamespaceStore(NewFileNamespaceStore(storePath)))
+		ns, ok := restarted.config().Namespaces["pr-123"]
+		if !ok || ns.Source != NAMESPACE_SOURCE_ADMIN {
+			t.Errorf("Expected pr-123 to survive a restart, got %+v", ns)
+		}
+		if _, ok := restarted.config().Namespaces["cool-otter"]; !ok {
+			t.Error("Expected cool-otter from the configuration file")
+		}
+	})
+}
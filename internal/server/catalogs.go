@@ -0,0 +1,102 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// validateHostGlob checks a catalog key, a host name in which * matches
+// any part of a label.
+func validateHostGlob(glob string) error {
+	if glob == "" || strings.ContainsAny(glob, "/:") || glob != strings.ToLower(glob) {
+		return errors.New("must be a lower-case host name or glob, such as *.shop.int.kube")
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// catalogFor returns the key of the catalog for host, which may include a
+// port.  A host name takes precedence over globs, and longer globs over
+// shorter ones.
+func (cfg AuthzConfig) catalogFor(host string) (string, bool) {
+	host, _, _ = strings.Cut(strings.ToLower(host), ":")
+	if host == "" {
+		return "", false
+	}
+	if _, ok := cfg.Catalogs[host]; ok {
+		return host, true
+	}
+	keys := slices.SortedFunc(maps.Keys(cfg.Catalogs), func(a, b string) int {
+		return cmp.Or(len(b)-len(a), strings.Compare(a, b))
+	})
+	for _, key := range keys {
+		if ok, _ := path.Match(key, host); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// cookieName returns the name of the namespace cookie for the catalog with
+// key.  It defaults to the server's cookieName suffixed with the key, so that
+// selecting a namespace for one application leaves the others on the same
+// cookie domain alone.
+func (c CatalogConfig) cookieName(server ServerConfig, key string) string {
+	if c.CookieName != "" {
+		return c.CookieName
+	}
+	return server.CookieName + "-" + strings.ReplaceAll(key, "*", "_")
+}
+
+// forHost returns cfg with the namespaces and namespace cookie of the catalog
+// for host, if there is one.  The client identity cookie stays shared.
+// Namespaces managed through the admin API, discovered from Kubernetes or
+// matched by patterns only appear outside catalogs.
+func (cfg AuthzConfig) forHost(host string) AuthzConfig {
+	key, ok := cfg.catalogFor(host)
+	if !ok {
+		return cfg
+	}
+	catalog := cfg.Catalogs[key]
+	cookieName := catalog.cookieName(cfg.Server, key)
+	cfg.Server.Extractors = slices.Clone(cfg.Server.Extractors)
+	for i, e := range cfg.Server.Extractors {
+		if e.Type == EXTRACTOR_COOKIE && e.Name == cfg.Server.CookieName {
+			cfg.Server.Extractors[i].Name = cookieName
+		}
+	}
+	cfg.cookieName = cookieName
+	cfg.Namespaces = catalog.Namespaces
+	cfg.Patterns = nil
+	cfg.catalog = key
+	return cfg
+}
+
+// selectorCookie returns the name of the namespace cookie that the selector
+// sets for cfg.
+func (cfg AuthzConfig) selectorCookie() string {
+	return cmp.Or(cfg.cookieName, cfg.Server.CookieName)
+}
+
+// forRedirect returns cfg for the host of redirectTo or, if it has none,
+// for host.
+func (cfg AuthzConfig) forRedirect(redirectTo *string, host string) AuthzConfig {
+	if redirectTo != nil {
+		if u, err := url.Parse(*redirectTo); err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+	return cfg.forHost(host)
+}
+
+// title returns the title of the catalog cfg holds the namespaces of.
+func (cfg AuthzConfig) title() string {
+	return cfg.Catalogs[cfg.catalog].Title
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/michaelw/ext-authz-router/api"
+	"google.golang.org/grpc/codes"
+)
+
+const catalogsConfig = `
+templates:
+  shop:
+    description: Shop environment
+namespaces:
+  cool-otter:
+    target: blue
+catalogs:
+  shop.int.kube:
+    title: Shop environments
+    namespaces:
+      checkout-v2:
+        extends: shop
+        target: shop-green
+  "*.billing.int.kube":
+    namespaces:
+      invoices:
+        target: billing-blue
+  "*.int.kube":
+    namespaces:
+      cool-otter:
+        target: fallback
+`
+
+func TestCatalogFor(t *testing.T) {
+	cfg, err := ParseConfig([]byte(catalogsConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tests := map[string]string{
+		"shop.int.kube":              "shop.int.kube",
+		"Shop.int.kube:8443":         "shop.int.kube",
+		"eu.billing.int.kube":        "*.billing.int.kube",
+		"admin.int.kube":             "*.int.kube",
+		"billing.int.kube":           "*.int.kube",
+		"envdemo.example.com":        "",
+		"":                           "",
+		"cool-otter.shop.int.kube.x": "",
+	}
+	for host, expected := range tests {
+		if key, _ := cfg.catalogFor(host); key != expected {
+			t.Errorf("catalogFor(%q): expected %q, got %q", host, expected, key)
+		}
+	}
+}
+
+func TestCatalogs(t *testing.T) {
+	handler := newTestHandler(t, catalogsConfig)
+
+	t.Run("check", func(t *testing.T) {
+		type testCase struct {
+			host, namespace string
+			target          string
+		}
+		tests := []testCase{
+			{"shop.int.kube", "checkout-v2", "shop-green"},
+			{"shop.int.kube", "cool-otter", ""},
+			{"eu.billing.int.kube", "invoices", "billing-blue"},
+			{"admin.int.kube", "cool-otter", "fallback"},
+			{"envdemo.test", "cool-otter", "blue"},
+			{"envdemo.test", "checkout-v2", ""},
+		}
+		server := NewAuthzGRPCServer(handler)
+		for _, tc := range tests {
+			resp, err := server.Check(context.Background(), newCheckRequest(tc.host, "/", map[string]string{"x-namespace": tc.namespace}))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.target == "" {
+				if code := codes.Code(resp.GetStatus().GetCode()); code != codes.PermissionDenied {
+					t.Errorf("%s on %s: expected permission denied, got %v", tc.namespace, tc.host, code)
+				}
+				continue
+			}
+			if target := responseHeaders(resp)["x-backend"]; target != tc.target {
+				t.Errorf("%s on %s: expected target %q, got %q", tc.namespace, tc.host, tc.target, target)
+			}
+		}
+	})
+
+	t.Run("selector", func(t *testing.T) {
+		redirectTo := "https://shop.int.kube/cart"
+		resp, err := handler.GetNamespaces(context.Background(), api.GetNamespacesRequestObject{
+			Params: api.GetNamespacesParams{RedirectTo: &redirectTo},
+		})
+		if err != nil {
+			t.Fatalf("GetNamespaces failed: %v", err)
+		}
+		list := resp.(api.GetNamespaces200JSONResponse)
+		var ids []string
+		for id := range list.Namespaces {
+			ids = append(ids, id)
+		}
+		if !slices.Equal(ids, []string{"checkout-v2"}) {
+			t.Errorf("Expected only the shop namespaces, got %v", ids)
+		}
+		if desc := list.Namespaces["checkout-v2"].Description; desc == nil || *desc != "Shop environment" {
+			t.Errorf("Expected the description from the template, got %v", desc)
+		}
+		if list.Title == nil || *list.Title != "Shop environments" {
+			t.Errorf("Expected the catalog title, got %v", list.Title)
+		}
+
+		for _, tc := range []struct {
+			namespace string
+			accepted  bool
+		}{{"checkout-v2", true}, {"cool-otter", false}} {
+			resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+				Params:   api.PostSubmitParams{RedirectTo: &redirectTo},
+				JSONBody: &api.PostSubmitJSONRequestBody{Value: tc.namespace},
+			})
+			if err != nil {
+				t.Fatalf("PostSubmit failed: %v", err)
+			}
+			if _, ok := resp.(api.PostSubmit302JSONResponse); ok != tc.accepted {
+				t.Errorf("Selecting %s for %s: expected accepted %v, got %T", tc.namespace, redirectTo, tc.accepted, resp)
+			}
+		}
+	})
+
+	t.Run("cookies are scoped to the catalog", func(t *testing.T) {
+		// select a namespace for each application, as a browser would
+		cookies := map[string]string{}
+		for redirectTo, namespace := range map[string]string{"https://shop.int.kube/": "checkout-v2", "https://eu.billing.int.kube/": "invoices"} {
+			resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+				Params:   api.PostSubmitParams{RedirectTo: &redirectTo},
+				JSONBody: &api.PostSubmitJSONRequestBody{Value: namespace},
+			})
+			if err != nil {
+				t.Fatalf("PostSubmit failed: %v", err)
+			}
+			cookie, _, _ := strings.Cut(resp.(api.PostSubmit302JSONResponse).Headers.SetCookie, ";")
+			name, value, _ := strings.Cut(cookie, "=")
+			cookies[name] = value
+		}
+		expected := map[string]string{"namespace-shop.int.kube": "checkout-v2", "namespace-_.billing.int.kube": "invoices"}
+		if !maps.Equal(cookies, expected) {
+			t.Fatalf("Expected cookies %v, got %v", expected, cookies)
+		}
+		var header []string
+		for name, value := range cookies {
+			header = append(header, name+"="+value)
+		}
+
+		server := NewAuthzGRPCServer(handler)
+		for host, target := range map[string]string{"shop.int.kube": "shop-green", "eu.billing.int.kube": "billing-blue"} {
+			resp, err := server.Check(context.Background(), newCheckRequest(host, "/", map[string]string{"cookie": strings.Join(header, "; "), "accept": "text/html"}))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := responseHeaders(resp)["x-backend"]; got != target {
+				t.Errorf("%s: expected target %q, got %q", host, target, got)
+			}
+		}
+
+		// another application has no namespace selected yet
+		resp, err := server.Check(context.Background(), newCheckRequest("admin.int.kube", "/", map[string]string{"cookie": strings.Join(header, "; "), "accept": "text/html"}))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if location := responseHeaders(resp)["location"]; !strings.HasPrefix(location, "http://namespaces.test/?redirect_to=") {
+			t.Errorf("Expected a redirect to the selector, got %v", resp)
+		}
+	})
+
+	t.Run("grants and reservations are scoped to the catalog", func(t *testing.T) {
+		handler := newTestHandler(t, `
+namespaces:
+  staging:
+    target: staging
+    restricted: true
+  qa:
+    target: qa
+catalogs:
+  shop.int.kube:
+    namespaces:
+      staging:
+        target: shop-prod-data
+        restricted: true
+      qa:
+        target: shop-qa
+`)
+		router := newAdminRouter(t, handler)
+		alice, bob := &client{router: router}, &client{router: router}
+		const global, shop = "?redirect_to=https%3A%2F%2Fenvdemo.test%2F", "?redirect_to=https%3A%2F%2Fshop.int.kube%2F"
+
+		rec := alice.do(http.MethodPost, "/namespaces/staging/access-requests"+global, `{"requester": "alice"}`)
+		var r api.AccessRequest
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil || rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := adminRequest(router, http.MethodPost, "/admin/access-requests/"+r.Id+"/approve", "secret", "{}"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		server := NewAuthzGRPCServer(handler)
+		for host, expected := range map[string]codes.Code{"envdemo.test": codes.OK, "shop.int.kube": codes.PermissionDenied} {
+			cookie := alice.cookies["namespace"+CLIENT_COOKIE_SUFFIX]
+			resp, err := server.Check(context.Background(), newCheckRequest(host, "/", map[string]string{"x-namespace": "staging", "cookie": cookie.Name + "=" + cookie.Value}))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if code := codes.Code(resp.GetStatus().GetCode()); code != expected {
+				t.Errorf("staging on %s: expected %v, got %v", host, expected, code)
+			}
+		}
+
+		rec = alice.do(http.MethodPost, "/namespaces/staging/access-requests"+shop, `{"requester": "alice"}`)
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil || rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202 for the catalog's namespace, got %d: %s", rec.Code, rec.Body)
+		}
+		if r.Status != api.Pending || r.Catalog == nil || *r.Catalog != "shop.int.kube" {
+			t.Errorf("Expected a pending request for the catalog, got %+v", r)
+		}
+
+		if rec := alice.do(http.MethodPut, "/namespaces/qa/reservation"+shop, `{"holder": "alice"}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := bob.do(http.MethodPut, "/namespaces/qa/reservation"+global, `{"holder": "bob"}`); rec.Code != http.StatusOK {
+			t.Errorf("Expected qa outside the catalog to be free, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := bob.do(http.MethodPut, "/namespaces/qa/reservation"+shop, `{"holder": "bob"}`); rec.Code != http.StatusConflict {
+			t.Errorf("Expected qa in the catalog to be reserved, got %d: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("reloads are diffed", func(t *testing.T) {
+		var diffs []ConfigDiff
+		handler := newTestHandler(t, catalogsConfig, WithEventListener(func(e Event) {
+			if e.Type == EVENT_CONFIG_CHANGED {
+				diffs = append(diffs, *e.Diff)
+			}
+		}))
+		changed := strings.Replace(catalogsConfig, "target: shop-green", "target: shop-blue", 1)
+		changed = strings.Replace(changed, "      invoices:\n        target: billing-blue\n", "      receipts:\n        target: billing-blue\n", 1)
+		if err := os.WriteFile(handler.configPath, []byte(changed), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+
+		expected := []ConfigDiff{{
+			Generation: 2,
+			Added:      []NamespaceChange{{Namespace: "receipts", Catalog: "*.billing.int.kube", Target: "billing-blue", Source: "config.yaml"}},
+			Removed:    []NamespaceChange{{Namespace: "invoices", Catalog: "*.billing.int.kube", PreviousTarget: "billing-blue", Source: "config.yaml"}},
+			Retargeted: []NamespaceChange{{Namespace: "checkout-v2", Catalog: "shop.int.kube", Target: "shop-blue", PreviousTarget: "shop-green", Source: "config.yaml"}},
+		}}
+		if !reflect.DeepEqual(diffs, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, diffs)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		cfg, err := ParseConfig([]byte("namespaces: {}\ncatalogs:\n  \"[shop\":\n    namespaces:\n      a:\n        target: x\n  Shop.int.kube:\n    namespaces:\n      b: {}\n"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = cfg.Validate()
+		for _, expected := range []string{"catalogs.[shop: syntax error", "catalogs.Shop.int.kube: must be a lower-case host name", "catalogs.Shop.int.kube.namespaces.b.target: must not be empty"} {
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error %q, got %v", expected, err)
+			}
+		}
+	})
+}
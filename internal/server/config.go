@@ -1,31 +1,216 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"reflect"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// WithConfig sets the configuration path, either a single YAML file or a
+// directory of YAML fragments that are merged.
 func WithConfig(path string) HandlerOption {
 	return func(h *AuthzHandler) {
 		h.configPath = path
 	}
 }
 
-// loadConfig loads the namespace configuration from the YAML file
+// ParseConfig decodes a YAML configuration document.  Unknown keys are
+// rejected, so that typos do not silently fall back to defaults.
+func ParseConfig(data []byte) (AuthzConfig, error) {
+	cfg, _, err := parseConfigDocuments([]configDocument{{Data: data}})
+	return cfg, err
+}
+
+// LoadConfig reads, parses and validates the configuration at path, which
+// may be a file or a directory of fragments.  It returns lint warnings
+// alongside a configuration that passed validation.
+func LoadConfig(path string) (AuthzConfig, []string, error) {
+	docs, _, err := readConfigDocuments(path)
+	if err != nil {
+		return AuthzConfig{}, nil, err
+	}
+	return loadConfigDocuments(docs)
+}
+
+// loadConfigDocuments parses and validates configuration documents.
+func loadConfigDocuments(docs []configDocument) (AuthzConfig, []string, error) {
+	cfg, warnings, err := parseConfigDocuments(docs)
+	if err != nil {
+		return AuthzConfig{}, warnings, err
+	}
+	if cfg.Server, err = cfg.Server.withDefaults(); err != nil {
+		return AuthzConfig{}, warnings, err
+	}
+	lint, err := cfg.Validate()
+	warnings = append(warnings, lint...)
+	if err != nil {
+		return AuthzConfig{}, warnings, err
+	}
+	return cfg, warnings, nil
+}
+
+// loadConfig loads the namespace configuration from the YAML file, or from
+// the configuration URL if one is set.
 func (h *AuthzHandler) loadConfig() error {
-	file, err := os.ReadFile(h.configPath)
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+	if h.remote != nil {
+		return h.loadRemoteConfig()
+	}
+	docs, manifest, err := readConfigDocuments(h.configPath)
 	if err != nil {
 		return err
 	}
-	var cfg AuthzConfig
-	if err := yaml.Unmarshal(file, &cfg); err != nil {
+	return h.loadDocuments(docs, manifest)
+}
+
+// loadDocuments loads configuration documents, signed by manifest if it is
+// not nil, and makes them the current configuration.
+func (h *AuthzHandler) loadDocuments(docs []configDocument, manifest *configDocument) error {
+	if err := h.verifyDocuments(docs, manifest); err != nil {
 		return err
 	}
-	h.configLock.Lock()
-	defer h.configLock.Unlock()
-	h.currentConfig = cfg
-	log.Println("[config] reloaded")
+	cfg, warnings, err := loadConfigDocuments(docs)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		log.Printf("W: [config] %v", warning)
+	}
+	h.applyConfig(cfg, configChecksum(docs))
 	return nil
 }
+
+// applyConfig makes cfg the current configuration as a new generation.
+// Changes to the namespaces after the initial load are emitted as a diff.
+func (h *AuthzHandler) applyConfig(cfg AuthzConfig, checksum string) {
+	h.configLock.Lock()
+	before, reloaded := h.currentConfig, h.configLoaded
+	cfg.status = ConfigStatus{
+		Checksum:   checksum,
+		Generation: h.currentConfig.status.Generation + 1,
+		LoadedAt:   time.Now(),
+	}
+	h.currentConfig = h.withDiscoveredNamespaces(h.withManagedNamespaces(cfg))
+	h.configLoaded = true
+	diff := diffConfigs(before, h.currentConfig, cfg.status.Generation)
+	h.configLock.Unlock()
+	log.Printf("[config] reloaded (generation %d, checksum %.12s)", cfg.status.Generation, checksum)
+
+	if reloaded {
+		h.emitDiff(diff)
+	}
+}
+
+// config returns a snapshot of the current configuration.
+func (h *AuthzHandler) config() AuthzConfig {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.currentConfig
+}
+
+// decodeNode strictly decodes a YAML node into out, reporting unknown keys
+// with their line numbers.
+func decodeNode(node *yaml.Node, out any) error {
+	if err := checkKnownFields(node, reflect.TypeOf(out)); err != nil {
+		return err
+	}
+	return node.Decode(out)
+}
+
+// checkKnownFields reports mapping keys in node that do not correspond to a
+// field of typ.  This mirrors yaml.Decoder.KnownFields, which is not
+// available when decoding from a yaml.Node.
+func checkKnownFields(node *yaml.Node, typ reflect.Type) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return checkKnownFields(node.Content[0], typ)
+	case yaml.AliasNode:
+		return checkKnownFields(node.Alias, typ)
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	var errs []error
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil // type mismatches are reported by Decode
+		}
+		fields := yamlFields(typ)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, typ))
+				continue
+			}
+			errs = append(errs, checkKnownFields(value, field.Type))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, checkKnownFields(node.Content[i], typ.Elem()))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, item := range node.Content {
+			errs = append(errs, checkKnownFields(item, typ.Elem()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// yamlFields returns the fields of a struct type by YAML key, flattening
+// inlined structs.
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for key, inlined := range yamlFields(field.Type) {
+				fields[key] = inlined
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// mappingEntry returns the key and value nodes for key in a YAML mapping, or
+// nil if the key is not present.
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
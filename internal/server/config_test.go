@@ -138,3 +138,81 @@ namespaces:
 		}
 	})
 }
+
+func TestLoadConfigDirectory(t *testing.T) {
+	writeFragments := func(t *testing.T, fragments map[string]string) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, content := range fragments {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write fragment %s: %v", name, err)
+			}
+		}
+		return dir
+	}
+
+	t.Run("fragments are merged", func(t *testing.T) {
+		dir := writeFragments(t, map[string]string{
+			"team-a.yaml":  "namespaces:\n  cool-otter:\n    target: blue\n",
+			"team-b.yml":   "namespaces:\n  awesome-penguin:\n    target: red\n",
+			"README.md":    "not a fragment",
+			".hidden.yaml": "invalid yaml content: [[[",
+		})
+
+		cfg, _, err := LoadConfig(dir)
+		if err != nil {
+			t.Fatalf("Expected fragments to load, got: %v", err)
+		}
+
+		expectedSources := map[string]string{
+			"cool-otter":      "team-a.yaml",
+			"awesome-penguin": "team-b.yml",
+		}
+		if len(cfg.Namespaces) != len(expectedSources) {
+			t.Errorf("Expected %d namespaces, got %d", len(expectedSources), len(cfg.Namespaces))
+		}
+		for id, source := range expectedSources {
+			if cfg.Namespaces[id].Source != source {
+				t.Errorf("Expected namespace %s to come from %s, got %q", id, source, cfg.Namespaces[id].Source)
+			}
+		}
+	})
+
+	t.Run("duplicate namespaces are reported with file and line", func(t *testing.T) {
+		dir := writeFragments(t, map[string]string{
+			"team-a.yaml": "namespaces:\n  cool-otter:\n    target: blue\n",
+			"team-b.yaml": "namespaces:\n  awesome-penguin:\n    target: red\n  cool-otter:\n    target: green\n",
+		})
+
+		_, _, err := LoadConfig(dir)
+		expected := "team-b.yaml:4: namespace cool-otter already defined in team-a.yaml:2"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got: %v", expected, err)
+		}
+	})
+
+	t.Run("server settings in more than one fragment", func(t *testing.T) {
+		dir := writeFragments(t, map[string]string{
+			"a.yaml": "server:\n  cookieName: env\n",
+			"b.yaml": "namespaces: {}\nserver:\n  cookieDomain: example.com\n",
+		})
+
+		_, _, err := LoadConfig(dir)
+		expected := "b.yaml:2: server settings already defined in a.yaml:1"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got: %v", expected, err)
+		}
+	})
+
+	t.Run("errors name the fragment", func(t *testing.T) {
+		dir := writeFragments(t, map[string]string{
+			"team-a.yaml": "namespaces:\n  cool-otter:\n    taget: blue\n",
+		})
+
+		_, _, err := LoadConfig(dir)
+		expected := "team-a.yaml: line 3: field taget not found in type server.NamespaceConfig"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got: %v", expected, err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ConfigDiff describes how the namespaces, including those of catalogs,
+// changed from one configuration generation to the next.
+type ConfigDiff struct {
+	Generation int64             `json:"generation"`
+	Added      []NamespaceChange `json:"added,omitempty"`
+	Removed    []NamespaceChange `json:"removed,omitempty"`
+	Retargeted []NamespaceChange `json:"retargeted,omitempty"`
+}
+
+// NamespaceChange describes a namespace that was added, removed or
+// retargeted.
+type NamespaceChange struct {
+	Namespace string `json:"namespace"`
+	// Catalog is the key of the catalog the namespace belongs to, if any.
+	Catalog        string `json:"catalog,omitempty"`
+	Target         string `json:"target,omitempty"`
+	PreviousTarget string `json:"previousTarget,omitempty"`
+	Source         string `json:"source,omitempty"`
+}
+
+// diffNamespaces compares the namespaces before and after a change.
+// Changes are sorted by namespace ID.
+func diffNamespaces(before, after map[string]NamespaceConfig, generation int64) ConfigDiff {
+	diff := ConfigDiff{Generation: generation}
+	diff.compare("", before, after)
+	return diff
+}
+
+// diffConfigs compares the namespaces and the namespaces of the catalogs
+// before and after a reload.  Changes to the top-level namespaces come
+// first, followed by those of the catalogs in order of their keys.
+func diffConfigs(before, after AuthzConfig, generation int64) ConfigDiff {
+	diff := diffNamespaces(before.Namespaces, after.Namespaces, generation)
+	keys := slices.Collect(maps.Keys(before.Catalogs))
+	keys = append(keys, slices.Collect(maps.Keys(after.Catalogs))...)
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		diff.compare(key, before.Catalogs[key].Namespaces, after.Catalogs[key].Namespaces)
+	}
+	return diff
+}
+
+// compare appends the changes to the namespaces of a catalog, or to the
+// top-level namespaces if catalog is empty, sorted by namespace ID.
+func (d *ConfigDiff) compare(catalog string, before, after map[string]NamespaceConfig) {
+	for _, id := range slices.Sorted(maps.Keys(after)) {
+		ns := after[id]
+		previous, ok := before[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, NamespaceChange{Namespace: id, Catalog: catalog, Target: ns.Target, Source: ns.Source})
+		case previous.Target != ns.Target:
+			d.Retargeted = append(d.Retargeted, NamespaceChange{Namespace: id, Catalog: catalog, Target: ns.Target, PreviousTarget: previous.Target, Source: ns.Source})
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[id]; !ok {
+			ns := before[id]
+			d.Removed = append(d.Removed, NamespaceChange{Namespace: id, Catalog: catalog, PreviousTarget: ns.Target, Source: ns.Source})
+		}
+	}
+}
+
+// empty reports whether no namespace was added, removed or retargeted.
+func (d ConfigDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retargeted) == 0
+}
+
+// emitDiff emits a config.changed event for a non-empty diff.  It must not
+// be called with configLock held, as listeners may read the configuration.
+func (h *AuthzHandler) emitDiff(diff ConfigDiff) {
+	if diff.empty() {
+		return
+	}
+	h.emit(Event{
+		Type:    EVENT_CONFIG_CHANGED,
+		Message: fmt.Sprintf("generation %d: %d added, %d removed, %d retargeted", diff.Generation, len(diff.Added), len(diff.Removed), len(diff.Retargeted)),
+		Diff:    &diff,
+	})
+}
+
+// lookupNamespaces returns those of the namespaces ids that exist.
+func (h *AuthzHandler) lookupNamespaces(ctx context.Context, ids []string) map[string]NamespaceConfig {
+	cfg := h.config()
+	namespaces := map[string]NamespaceConfig{}
+	for _, id := range ids {
+		if ns, ok := h.namespace(ctx, cfg, id); ok {
+			namespaces[id] = ns
+		}
+	}
+	return namespaces
+}
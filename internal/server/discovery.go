@@ -0,0 +1,269 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kubernetes objects that namespaces can be discovered from
+const (
+	DISCOVERY_KIND_NAMESPACE = "Namespace"
+	DISCOVERY_KIND_SERVICE   = "Service"
+)
+
+const (
+	// Annotations of discovered objects.  The namespace ID and target
+	// default to the object name.
+	DISCOVERY_ANNOTATION_ID          = "ext-authz-router/id"
+	DISCOVERY_ANNOTATION_TARGET      = "ext-authz-router/target"
+	DISCOVERY_ANNOTATION_DESCRIPTION = "ext-authz-router/description"
+
+	DISCOVERY_RESYNC = 10 * time.Minute
+
+	// NAMESPACE_SOURCE_KUBERNETES prefixes the source of discovered
+	// namespaces, which is followed by the kind and name of the object.
+	NAMESPACE_SOURCE_KUBERNETES = "kubernetes:"
+)
+
+// DiscoveryConfig configures discovery of namespaces from Kubernetes.
+type DiscoveryConfig struct {
+	// Kind is DISCOVERY_KIND_NAMESPACE (the default) or
+	// DISCOVERY_KIND_SERVICE.
+	Kind string
+	// LabelSelector selects the objects to discover, such as
+	// "ext-authz-router/discover=true".
+	LabelSelector string
+	// Namespace limits the discovery of services to a Kubernetes namespace.
+	Namespace string
+}
+
+// discovery is the parsed discovery configuration.
+type discovery struct {
+	client    kubernetes.Interface
+	kind      string
+	selector  labels.Selector
+	namespace string
+}
+
+// WithDiscovery discovers namespaces from the Kubernetes objects selected by
+// cfg, see RunDiscovery.
+func WithDiscovery(client kubernetes.Interface, cfg DiscoveryConfig) HandlerOption {
+	return func(h *AuthzHandler) {
+		d, err := newDiscovery(client, cfg)
+		if err != nil {
+			log.Printf("E: Kubernetes discovery disabled: %v", err)
+			return
+		}
+		h.discovery = d
+	}
+}
+
+func newDiscovery(client kubernetes.Interface, cfg DiscoveryConfig) (*discovery, error) {
+	if cfg.Kind == "" {
+		cfg.Kind = DISCOVERY_KIND_NAMESPACE
+	}
+	switch cfg.Kind {
+	case DISCOVERY_KIND_NAMESPACE:
+		if cfg.Namespace != "" {
+			return nil, errors.New("namespace: only services can be discovered in a namespace")
+		}
+	case DISCOVERY_KIND_SERVICE:
+	default:
+		return nil, fmt.Errorf("kind: %q is not %s or %s", cfg.Kind, DISCOVERY_KIND_NAMESPACE, DISCOVERY_KIND_SERVICE)
+	}
+	if cfg.LabelSelector == "" {
+		return nil, errors.New("no label selector")
+	}
+	selector, err := labels.Parse(cfg.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("label selector: %w", err)
+	}
+	return &discovery{
+		client:    client,
+		kind:      cfg.Kind,
+		selector:  selector,
+		namespace: cfg.Namespace,
+	}, nil
+}
+
+// inClusterDiscovery enables discovery with the service account of the pod.
+func inClusterDiscovery(cfg DiscoveryConfig) HandlerOption {
+	restConfig, err := rest.InClusterConfig()
+	if err == nil {
+		var client kubernetes.Interface
+		if client, err = kubernetes.NewForConfig(restConfig); err == nil {
+			return WithDiscovery(client, cfg)
+		}
+	}
+	return func(h *AuthzHandler) {
+		log.Printf("E: Kubernetes discovery disabled: %v", err)
+	}
+}
+
+// RunDiscovery watches the selected Kubernetes objects and merges the
+// namespaces derived from them into the configuration, until ctx is
+// cancelled.  Namespaces from the configuration and the admin API take
+// precedence over discovered ones with the same ID.
+func (h *AuthzHandler) RunDiscovery(ctx context.Context) error {
+	if h.discovery == nil {
+		return nil
+	}
+	d := h.discovery
+	factory := informers.NewSharedInformerFactoryWithOptions(d.client, DISCOVERY_RESYNC,
+		informers.WithNamespace(d.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = d.selector.String()
+		}),
+	)
+	var informer cache.SharedIndexInformer
+	switch d.kind {
+	case DISCOVERY_KIND_SERVICE:
+		informer = factory.Core().V1().Services().Informer()
+	default:
+		informer = factory.Core().V1().Namespaces().Informer()
+	}
+
+	// changes are coalesced, the store is read in full on every update
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify() },
+		UpdateFunc: func(any, any) { notify() },
+		DeleteFunc: func(any) { notify() },
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	log.Printf("I: discovering namespaces from Kubernetes %s objects matching %q", d.kind, d.selector)
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil
+	}
+	for {
+		h.applyDiscovered(d.namespaces(informer.GetStore().List()))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// namespaces derives namespaces from Kubernetes objects.  Invalid entries
+// are logged and skipped; of several objects claiming the same ID, the one
+// whose source sorts first wins.
+func (d *discovery) namespaces(objects []any) map[string]NamespaceConfig {
+	namespaces := map[string]NamespaceConfig{}
+	for _, obj := range objects {
+		meta, ok := obj.(metav1.Object)
+		if !ok || !d.selector.Matches(labels.Set(meta.GetLabels())) {
+			continue
+		}
+		source := NAMESPACE_SOURCE_KUBERNETES + d.kind + "/" + meta.GetName()
+		if meta.GetNamespace() != "" {
+			source = NAMESPACE_SOURCE_KUBERNETES + d.kind + "/" + meta.GetNamespace() + "/" + meta.GetName()
+		}
+		annotations := meta.GetAnnotations()
+		id := cmp.Or(annotations[DISCOVERY_ANNOTATION_ID], meta.GetName())
+		ns := NamespaceConfig{
+			Target:      cmp.Or(annotations[DISCOVERY_ANNOTATION_TARGET], meta.GetName()),
+			Description: annotations[DISCOVERY_ANNOTATION_DESCRIPTION],
+			Source:      source,
+		}
+		if err := validateManagedNamespace(AuthzConfig{}, id, ns); err != nil {
+			log.Printf("W: [discovery] skipping %s: %v", source, err)
+			continue
+		}
+		if other, ok := namespaces[id]; ok {
+			if other.Source < source {
+				log.Printf("W: [discovery] skipping %s: namespace %s is already defined by %s", source, id, other.Source)
+				continue
+			}
+			log.Printf("W: [discovery] skipping %s: namespace %s is already defined by %s", other.Source, id, source)
+		}
+		namespaces[id] = ns
+	}
+	return namespaces
+}
+
+// isDiscovered reports whether ns was discovered from Kubernetes.
+func (ns NamespaceConfig) isDiscovered() bool {
+	return strings.HasPrefix(ns.Source, NAMESPACE_SOURCE_KUBERNETES)
+}
+
+// applyDiscovered replaces the discovered namespaces and applies them as a
+// new generation of the current configuration, unless they are unchanged.
+func (h *AuthzHandler) applyDiscovered(discovered map[string]NamespaceConfig) {
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+	h.configLock.Lock()
+	if h.discoveryLoaded && maps.Equal(h.discovered, discovered) {
+		h.configLock.Unlock()
+		return
+	}
+
+	before := h.currentConfig.Namespaces
+	namespaces := maps.Clone(before)
+	for id, ns := range namespaces {
+		if ns.isDiscovered() {
+			delete(namespaces, id)
+		}
+	}
+	h.currentConfig.Namespaces = namespaces
+	h.discovered = discovered
+	h.discoveryLoaded = true
+	h.currentConfig = h.withDiscoveredNamespaces(h.currentConfig)
+	h.currentConfig.status.Generation++
+	h.currentConfig.status.LoadedAt = time.Now()
+	diff := diffNamespaces(before, h.currentConfig.Namespaces, h.currentConfig.status.Generation)
+	h.configLock.Unlock()
+	log.Printf("[config] %d namespaces discovered in Kubernetes: %s (generation %d)", len(discovered), strings.Join(slices.Sorted(maps.Keys(discovered)), ", "), diff.Generation)
+
+	h.emitDiff(diff)
+}
+
+// withDiscoveredNamespaces returns cfg with the discovered namespaces added,
+// except where cfg already has a namespace with the same ID.  Callers must
+// hold configLock.
+func (h *AuthzHandler) withDiscoveredNamespaces(cfg AuthzConfig) AuthzConfig {
+	if len(h.discovered) == 0 {
+		return cfg
+	}
+	namespaces := maps.Clone(cfg.Namespaces)
+	if namespaces == nil {
+		namespaces = map[string]NamespaceConfig{}
+	}
+	for _, id := range slices.Sorted(maps.Keys(h.discovered)) {
+		ns := h.discovered[id]
+		if existing, ok := namespaces[id]; ok {
+			if !existing.isDiscovered() {
+				log.Printf("W: [config] namespace %s from %s is shadowed by %s", id, ns.Source, cmp.Or(existing.Source, NAMESPACE_SOURCE_ADMIN))
+			}
+			continue
+		}
+		namespaces[id] = ns
+	}
+	cfg.Namespaces = namespaces
+	return cfg
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const discoveryLabel = "ext-authz-router/discover"
+
+func discoverableNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{discoveryLabel: "true"},
+		Annotations: annotations,
+	}}
+}
+
+// runDiscovery runs discovery for handler against a fake cluster with
+// objects, until the test ends.
+func runDiscovery(t *testing.T, handler *AuthzHandler, cfg DiscoveryConfig, objects ...runtime.Object) *fake.Clientset {
+	t.Helper()
+	client := fake.NewClientset(objects...)
+	WithDiscovery(client, cfg)(handler)
+	if handler.discovery == nil {
+		t.Fatal("Expected discovery to be enabled")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.RunDiscovery(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return client
+}
+
+// waitForNamespace waits until namespace id has the given target, or is
+// absent if target is empty.
+func waitForNamespace(t *testing.T, handler *AuthzHandler, id, target string) NamespaceConfig {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ns, ok := handler.config().Namespaces[id]
+		if (target == "" && !ok) || (ok && ns.Target == target) {
+			return ns
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected namespace %s with target %q, got %+v", id, target, ns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+`
+
+	t.Run("namespaces", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		client := runDiscovery(t, handler, DiscoveryConfig{LabelSelector: discoveryLabel + "=true"},
+			discoverableNamespace("service-red", map[string]string{
+				DISCOVERY_ANNOTATION_ID:          "awesome-penguin",
+				DISCOVERY_ANNOTATION_TARGET:      "red",
+				DISCOVERY_ANNOTATION_DESCRIPTION: "Awesome Penguin",
+			}),
+			discoverableNamespace("yellow", nil),
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		)
+
+		ns := waitForNamespace(t, handler, "awesome-penguin", "red")
+		if ns.Description != "Awesome Penguin" || ns.Source != "kubernetes:Namespace/service-red" {
+			t.Errorf("Expected description and source from the Namespace, got %+v", ns)
+		}
+		if ns := waitForNamespace(t, handler, "yellow", "yellow"); ns.Description != "" {
+			t.Errorf("Expected no description, got %q", ns.Description)
+		}
+		if _, ok := handler.config().Namespaces["kube-system"]; ok {
+			t.Error("Expected unlabeled kube-system not to be discovered")
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Errorf("Expected static cool-otter to be kept, got %q", target)
+		}
+
+		// changes in the cluster are picked up
+		ctx := context.Background()
+		updated := discoverableNamespace("service-red", map[string]string{DISCOVERY_ANNOTATION_ID: "awesome-penguin", DISCOVERY_ANNOTATION_TARGET: "magenta"})
+		if _, err := client.CoreV1().Namespaces().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Failed to update Namespace: %v", err)
+		}
+		waitForNamespace(t, handler, "awesome-penguin", "magenta")
+		if err := client.CoreV1().Namespaces().Delete(ctx, "yellow", metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Failed to delete Namespace: %v", err)
+		}
+		waitForNamespace(t, handler, "yellow", "")
+
+		// and survive configuration reloads
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		waitForNamespace(t, handler, "awesome-penguin", "magenta")
+	})
+
+	t.Run("services", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		service := func(namespace, name string) *corev1.Service {
+			return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    map[string]string{discoveryLabel: "true"},
+			}}
+		}
+		runDiscovery(t, handler, DiscoveryConfig{Kind: DISCOVERY_KIND_SERVICE, LabelSelector: discoveryLabel, Namespace: "envdemo"},
+			service("envdemo", "red"), service("other", "blue"))
+
+		if ns := waitForNamespace(t, handler, "red", "red"); ns.Source != "kubernetes:Service/envdemo/red" {
+			t.Errorf("Expected source kubernetes:Service/envdemo/red, got %s", ns.Source)
+		}
+		if _, ok := handler.config().Namespaces["blue"]; ok {
+			t.Error("Expected services in other namespaces not to be discovered")
+		}
+	})
+
+	t.Run("static and admin namespaces take precedence", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newAdminRouter(t, handler)
+		runDiscovery(t, handler, DiscoveryConfig{LabelSelector: discoveryLabel},
+			discoverableNamespace("cool-otter", nil),
+			discoverableNamespace("yellow", nil),
+		)
+		waitForNamespace(t, handler, "yellow", "yellow")
+
+		if ns := handler.config().Namespaces["cool-otter"]; ns.Target != "blue" || ns.isDiscovered() {
+			t.Errorf("Expected static cool-otter, got %+v", ns)
+		}
+		if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/yellow", "secret", `{"target": "gold"}`); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for a discovered namespace, got %d", rec.Code)
+		}
+	})
+}
+
+func TestDiscoveredNamespaces(t *testing.T) {
+	d, err := newDiscovery(nil, DiscoveryConfig{LabelSelector: discoveryLabel})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	namespaces := d.namespaces([]any{
+		discoverableNamespace("b", map[string]string{DISCOVERY_ANNOTATION_ID: "shared"}),
+		discoverableNamespace("a", map[string]string{DISCOVERY_ANNOTATION_ID: "shared"}),
+		discoverableNamespace("bad", map[string]string{DISCOVERY_ANNOTATION_ID: "a;b"}),
+		discoverableNamespace("untrimmed", map[string]string{DISCOVERY_ANNOTATION_TARGET: " red"}),
+	})
+	if len(namespaces) != 1 || namespaces["shared"].Source != "kubernetes:Namespace/a" {
+		t.Errorf("Expected only shared from Namespace a, got %+v", namespaces)
+	}
+}
+
+func TestNewDiscovery(t *testing.T) {
+	type testCase struct {
+		name string
+		cfg  DiscoveryConfig
+		err  string
+	}
+	tests := []testCase{
+		{name: "namespaces", cfg: DiscoveryConfig{LabelSelector: "team=web"}},
+		{name: "services", cfg: DiscoveryConfig{Kind: DISCOVERY_KIND_SERVICE, LabelSelector: "team", Namespace: "envdemo"}},
+		{name: "no selector", cfg: DiscoveryConfig{}, err: "no label selector"},
+		{name: "invalid selector", cfg: DiscoveryConfig{LabelSelector: "team in (web"}, err: "label selector"},
+		{name: "unknown kind", cfg: DiscoveryConfig{Kind: "Pod", LabelSelector: "team"}, err: "kind"},
+		{name: "namespaced namespaces", cfg: DiscoveryConfig{LabelSelector: "team", Namespace: "envdemo"}, err: "only services"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newDiscovery(nil, tc.cfg)
+			if tc.err == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("Expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Event types
+const (
+	EVENT_NAMESPACE_EXPIRED = "namespace.expired"
+	EVENT_CONFIG_CHANGED    = "config.changed"
+)
+
+// Event describes something that happened to the routing configuration.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	// Diff is set for EVENT_CONFIG_CHANGED.
+	Diff *ConfigDiff `json:"diff,omitempty"`
+}
+
+// EventListener is called for every event.  It must not block.
+type EventListener func(Event)
+
+// WithEventListener adds a listener for events.
+func WithEventListener(listener EventListener) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.eventListeners = append(h.eventListeners, listener)
+	}
+}
+
+// emit logs an event and passes it to the listeners.
+func (h *AuthzHandler) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("E: [event] %v", err)
+		return
+	}
+	log.Printf("I: [event] %s", data)
+	for _, listener := range h.eventListeners {
+		listener(event)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+const JANITOR_INTERVAL = time.Minute
+
+// WithJanitorInterval sets how often the janitor purges expired namespaces.
+func WithJanitorInterval(d time.Duration) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.janitorInterval = d
+	}
+}
+
+// expired reports whether the namespace has expired at now.
+func (ns NamespaceConfig) expired(now time.Time) bool {
+	return ns.ExpiresAt != nil && !now.Before(*ns.ExpiresAt)
+}
+
+// requestedExpiry returns the expiry requested through the admin API, either
+// as a time or as a TTL relative to now.  The TTL is resolved once, so that
+// updating a namespace without a TTL does not extend it.
+func requestedExpiry(expiresAt *time.Time, ttl *string, now time.Time) (*time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != nil:
+		return nil, errors.New("expiresAt and ttl are mutually exclusive")
+	case ttl != nil:
+		d, err := time.ParseDuration(*ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("ttl must be positive, got %s", *ttl)
+		}
+		t := now.Add(d).UTC().Truncate(time.Second)
+		return &t, nil
+	case expiresAt != nil:
+		if !now.Before(*expiresAt) {
+			return nil, fmt.Errorf("expiresAt %s is in the past", expiresAt.Format(time.RFC3339))
+		}
+		t := expiresAt.UTC()
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// RunJanitor purges expired namespaces managed through the admin API,
+// expired reservations and access grants, stale access requests, and the
+// pattern namespaces the selector stopped offering every janitor interval,
+// until ctx is cancelled.  Expired namespaces defined in the configuration
+// cannot be purged; they are ignored until removed from it.
+func (h *AuthzHandler) RunJanitor(ctx context.Context) error {
+	ticker := time.NewTicker(h.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			h.purgeExpired(ctx, now)
+			h.purgeReservations(now)
+			h.purgeAccessRequests(now)
+			h.purgePatterns(now)
+		}
+	}
+}
+
+// purgeExpired deletes the namespaces managed through the admin API that
+// have expired at now, and returns their IDs.
+func (h *AuthzHandler) purgeExpired(ctx context.Context, now time.Time) []string {
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	var purged []string
+	namespaces := h.namespaces(ctx, h.config())
+	for _, id := range slices.Sorted(maps.Keys(namespaces)) {
+		ns := namespaces[id]
+		if ns.Source != NAMESPACE_SOURCE_ADMIN || !ns.expired(now) {
+			continue
+		}
+		if err := h.setManagedNamespace(ctx, id, nil); err != nil {
+			log.Printf("E: [janitor] %v", err)
+			continue
+		}
+		h.audit(ctx, AuditRecord{Actor: "janitor", Action: "expire", Namespace: id, Before: &ns})
+		h.emit(Event{
+			Type:      EVENT_NAMESPACE_EXPIRED,
+			Namespace: id,
+			Message:   fmt.Sprintf("namespace %s expired at %s and was purged", id, ns.ExpiresAt.Format(time.RFC3339)),
+		})
+		purged = append(purged, id)
+	}
+	return purged
+}
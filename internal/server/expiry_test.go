@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+func TestNamespaceExpiry(t *testing.T) {
+	handler := newTestHandler(t, `
+namespaces:
+  cool-otter:
+    target: blue
+    expiresAt: 2999-01-01T00:00:00Z
+  old-otter:
+    target: green
+    expiresAt: 2020-01-01T00:00:00Z
+`)
+
+	resp, err := handler.GetNamespaces(context.Background(), api.GetNamespacesRequestObject{})
+	if err != nil {
+		t.Fatalf("GetNamespaces failed: %v", err)
+	}
+	namespaces := resp.(api.GetNamespaces200JSONResponse).Namespaces
+	if _, ok := namespaces["cool-otter"]; !ok {
+		t.Error("Expected cool-otter to be listed")
+	}
+	if _, ok := namespaces["old-otter"]; ok {
+		t.Error("Expected expired old-otter not to be listed")
+	}
+
+	submit, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+		JSONBody: &api.PostSubmitJSONRequestBody{Value: "old-otter"},
+	})
+	if err != nil {
+		t.Fatalf("PostSubmit failed: %v", err)
+	}
+	rejected, ok := submit.(api.PostSubmit400JSONResponse)
+	if !ok || rejected.Error == nil || *rejected.Error != "expired" {
+		t.Errorf("Expected 400 expired response, got %#v", submit)
+	}
+}
+
+func TestRequestedExpiry(t *testing.T) {
+	now := time.Date(2025, 8, 8, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+	ttl := func(s string) *string { return &s }
+
+	type testCase struct {
+		name      string
+		expiresAt *time.Time
+		ttl       *string
+		expected  *time.Time
+		err       string
+	}
+	tests := []testCase{
+		{name: "none"},
+		{name: "expiresAt", expiresAt: &future, expected: &future},
+		{name: "ttl", ttl: ttl("1h"), expected: &future},
+		{name: "both", expiresAt: &future, ttl: ttl("1h"), err: "mutually exclusive"},
+		{name: "invalid ttl", ttl: ttl("3 days"), err: "invalid ttl"},
+		{name: "negative ttl", ttl: ttl("-1h"), err: "must be positive"},
+		{name: "past", expiresAt: &past, err: "in the past"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := requestedExpiry(tc.expiresAt, tc.ttl, now)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if (got == nil) != (tc.expected == nil) || (got != nil && !got.Equal(*tc.expected)) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	for _, storeName := range []string{"namespaces.yaml", "namespaces.db"} {
+		t.Run(storeName, func(t *testing.T) {
+			store, err := OpenNamespaceStore(context.Background(), filepath.Join(t.TempDir(), storeName))
+			if err != nil {
+				t.Fatalf("Failed to open store: %v", err)
+			}
+			var auditLog bytes.Buffer
+			var events []Event // besides config.changed
+			handler := newTestHandler(t, `
+namespaces:
+  old-otter:
+    target: green
+    expiresAt: 2020-01-01T00:00:00Z
+`, WithNamespaceStore(store), WithAuditLog(&auditLog), WithEventListener(func(e Event) {
+				if e.Type != EVENT_CONFIG_CHANGED {
+					events = append(events, e)
+				}
+			}))
+			router := newAdminRouter(t, handler)
+
+			for _, body := range []string{
+				`{"target": "pr-1", "ttl": "1h"}`,
+				`{"target": "pr-2", "expiresAt": "2999-01-01T00:00:00Z"}`,
+				`{"target": "pr-3"}`,
+			} {
+				var spec api.AdminNamespaceSpec
+				json.Unmarshal([]byte(body), &spec)
+				if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/"+spec.Target, "secret", body); rec.Code != http.StatusCreated {
+					t.Fatalf("Expected 201 for %s, got %d: %s", body, rec.Code, rec.Body)
+				}
+			}
+			auditLog.Reset()
+
+			purged := handler.purgeExpired(context.Background(), time.Now().Add(2*time.Hour))
+			if strings.Join(purged, ",") != "pr-1" {
+				t.Errorf("Expected pr-1 to be purged, got %v", purged)
+			}
+			if _, ok := handler.namespace(context.Background(), handler.config(), "pr-1"); ok {
+				t.Error("Expected pr-1 to be deleted")
+			}
+			for _, id := range []string{"pr-2", "pr-3", "old-otter"} {
+				if _, ok := handler.namespace(context.Background(), handler.config(), id); !ok {
+					t.Errorf("Expected %s to be kept", id)
+				}
+			}
+
+			if len(events) != 1 || events[0].Type != EVENT_NAMESPACE_EXPIRED || events[0].Namespace != "pr-1" {
+				t.Errorf("Expected a namespace.expired event for pr-1, got %+v", events)
+			}
+			var record AuditRecord
+			if err := json.Unmarshal(auditLog.Bytes(), &record); err != nil {
+				t.Fatalf("Invalid audit record %q: %v", auditLog.String(), err)
+			}
+			if record.Actor != "janitor" || record.Action != "expire" || record.Namespace != "pr-1" {
+				t.Errorf("Unexpected audit record: %s", auditLog.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Extractor types
+const (
+	EXTRACTOR_COOKIE  = "cookie"
+	EXTRACTOR_HEADER  = "header"
+	EXTRACTOR_QUERY   = "query"
+	EXTRACTOR_JWT     = "jwt"
+	EXTRACTOR_BAGGAGE = "baggage"
+	EXTRACTOR_HOST    = "host"
+	EXTRACTOR_PATH    = "path"
+)
+
+// EXTRACTOR_PATH_PREFIX is the default prefix of path extractors, which take
+// the namespace ID from the path segment following it, as in
+// /_ns/cool-otter/hooks.
+const EXTRACTOR_PATH_PREFIX = "/_ns"
+
+// EXTRACTOR_HOST_GROUP is the name of the submatch of a host pattern that
+// holds the namespace ID.  Without it, the first submatch is used.
+const EXTRACTOR_HOST_GROUP = "namespace"
+
+// JWT_AUTHN_METADATA is the metadata namespace in which Envoy's jwt_authn
+// filter stores the payloads of validated tokens (payload_in_metadata).
+const JWT_AUTHN_METADATA = "envoy.filters.http.jwt_authn"
+
+// Keys of the dynamic metadata set on check responses, which record the
+// namespace and the extractor it came from.  Envoy makes them available to
+// access logs, e.g. as %DYNAMIC_METADATA(envoy.filters.http.ext_authz:extractor)%.
+const (
+	DECISION_METADATA_NAMESPACE = "namespace"
+	DECISION_METADATA_EXTRACTOR = "extractor"
+)
+
+// Extractor takes the namespace ID from one part of the request.  Name is
+// the cookie, header, query parameter, JWT claim (with dots for nested
+// claims), baggage key or path prefix; it defaults to the cookie name,
+// x-<cookie name> for the header, or EXTRACTOR_PATH_PREFIX for the path.
+//
+// The host extractor takes it from the host name instead, either from the
+// label at position Label (0 is the leftmost) or from a submatch of Pattern.
+type Extractor struct {
+	Type    string `yaml:"type" json:"type"`
+	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
+	Label   *int   `yaml:"label,omitempty" json:"label,omitempty"`
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// String returns the extractor as in the EXTRACTORS environment variable,
+// such as "query:namespace" or "host:0".
+func (e Extractor) String() string {
+	switch {
+	case e.Label != nil:
+		return e.Type + ":" + strconv.Itoa(*e.Label)
+	case e.Pattern != "":
+		return e.Type + ":" + e.Pattern
+	case e.Name == "":
+		return e.Type
+	}
+	return e.Type + ":" + e.Name
+}
+
+// describe tells API clients how to supply a namespace with the extractor.
+func (e Extractor) describe() string {
+	switch e.Type {
+	case EXTRACTOR_COOKIE:
+		return "'" + e.Name + "' cookie"
+	case EXTRACTOR_HEADER:
+		return "'" + e.Name + "' header"
+	case EXTRACTOR_QUERY:
+		return "'" + e.Name + "' query parameter"
+	case EXTRACTOR_JWT:
+		return "'" + e.Name + "' JWT claim"
+	case EXTRACTOR_BAGGAGE:
+		return "'" + e.Name + "' baggage entry"
+	case EXTRACTOR_HOST:
+		return "host name"
+	case EXTRACTOR_PATH:
+		return "'" + e.Name + "/<namespace>' path prefix"
+	}
+	return e.String()
+}
+
+// parseExtractors parses a comma-separated list of extractors in the form
+// type or type:name, such as "cookie,query:ns".  For host extractors, the
+// name is the label position or, if it is not a number, the pattern.
+func parseExtractors(s string) []Extractor {
+	var extractors []Extractor
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		typ, name, _ := strings.Cut(field, ":")
+		e := Extractor{Type: typ, Name: name}
+		if typ == EXTRACTOR_HOST && name != "" {
+			e.Name = ""
+			if label, err := strconv.Atoi(name); err == nil {
+				e.Label = &label
+			} else {
+				e.Pattern = name
+			}
+		}
+		extractors = append(extractors, e)
+	}
+	return extractors
+}
+
+// withExtractorDefaults fills in the default names for the cookie name.
+func withExtractorDefaults(extractors []Extractor, cookieName string) []Extractor {
+	extractors = slices.Clone(extractors)
+	for i, e := range extractors {
+		if e.Pattern != "" {
+			extractors[i].pattern, _ = regexp.Compile(e.Pattern) // checked by validateExtractors
+		}
+		if e.Name != "" || e.Type == EXTRACTOR_HOST {
+			continue
+		}
+		switch e.Type {
+		case EXTRACTOR_HEADER:
+			extractors[i].Name = "x-" + cookieName
+		case EXTRACTOR_PATH:
+			extractors[i].Name = EXTRACTOR_PATH_PREFIX
+		default:
+			extractors[i].Name = cookieName
+		}
+	}
+	return extractors
+}
+
+// validateExtractors checks the extractor chain.
+func validateExtractors(extractors []Extractor) []error {
+	var errs []error
+	for i, e := range extractors {
+		path := fmt.Sprintf("server.extractors[%d]", i)
+		switch e.Type {
+		case EXTRACTOR_COOKIE, EXTRACTOR_HEADER:
+			if e.Name != "" && !isToken(e.Name) {
+				errs = append(errs, fmt.Errorf("%s.name: %q is not a valid %s name", path, e.Name, e.Type))
+			}
+		case EXTRACTOR_QUERY, EXTRACTOR_JWT, EXTRACTOR_BAGGAGE:
+		case EXTRACTOR_PATH:
+			if e.Name != "" && (!strings.HasPrefix(e.Name, "/") || strings.HasSuffix(e.Name, "/")) {
+				errs = append(errs, fmt.Errorf("%s.name: path prefix %q must start with a slash and not end with one", path, e.Name))
+			}
+		case EXTRACTOR_HOST:
+			errs = append(errs, validateHostExtractor(path, e)...)
+			continue
+		default:
+			errs = append(errs, fmt.Errorf("%s.type: %q is not one of %s", path, e.Type,
+				strings.Join([]string{EXTRACTOR_COOKIE, EXTRACTOR_HEADER, EXTRACTOR_QUERY, EXTRACTOR_JWT, EXTRACTOR_BAGGAGE, EXTRACTOR_HOST, EXTRACTOR_PATH}, ", ")))
+		}
+		if e.Label != nil || e.Pattern != "" {
+			errs = append(errs, fmt.Errorf("%s: label and pattern only apply to host extractors", path))
+		}
+	}
+	return errs
+}
+
+// readsCookie reports whether a cookie extractor reads the named cookie,
+// which the namespace selector sets.
+func readsCookie(extractors []Extractor, name string) bool {
+	return slices.ContainsFunc(extractors, func(e Extractor) bool {
+		return e.Type == EXTRACTOR_COOKIE && e.Name == name
+	})
+}
+
+// validateHostExtractor checks that a host extractor has either a label
+// position or a pattern with a submatch.
+func validateHostExtractor(path string, e Extractor) []error {
+	switch {
+	case e.Name != "":
+		return []error{fmt.Errorf("%s.name: host extractors take a label or pattern instead", path)}
+	case (e.Label == nil) == (e.Pattern == ""):
+		return []error{fmt.Errorf("%s: host extractors need either a label or a pattern", path)}
+	case e.Label != nil && *e.Label < 0:
+		return []error{fmt.Errorf("%s.label: must not be negative", path)}
+	case e.Pattern != "":
+		pattern, err := regexp.Compile(e.Pattern)
+		if err != nil {
+			return []error{fmt.Errorf("%s.pattern: %w", path, err)}
+		}
+		if pattern.NumSubexp() == 0 {
+			return []error{fmt.Errorf("%s.pattern: needs a submatch, such as (?P<%s>[^.]+)", path, EXTRACTOR_HOST_GROUP)}
+		}
+	}
+	return nil
+}
+
+// extraction is the result of running the extractor chain on a request.
+type extraction struct {
+	// Namespace is the namespace ID, empty if no extractor found one.
+	Namespace string
+	// Extractor is the extractor that supplied it.
+	Extractor Extractor
+	// RemoveQuery lists the query parameters that the chain reads, which
+	// are removed from the request before it is forwarded.
+	RemoveQuery []string
+	// Authority is the canonical host to forward the request to, if the
+	// namespace came from the host name.
+	Authority string
+	// Path is the path to forward the request with, without the prefix
+	// read by a path extractor.
+	Path string
+}
+
+// extractNamespace runs the extractors in order and returns the first
+// namespace ID found, except that a host name takes precedence wherever it
+// is in the chain.  A host name only supplies a namespace if known reports
+// that it exists, so that the canonical host falls through to the other
+// extractors.
+func extractNamespace(extractors []Extractor, req *envoy_service_auth_v3.AttributeContext, known func(string) bool) extraction {
+	httpReq := req.GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+	var query url.Values
+	if _, rawQuery, ok := strings.Cut(httpReq.GetPath(), "?"); ok {
+		query, _ = url.ParseQuery(rawQuery)
+	}
+
+	var result extraction
+	for _, e := range extractors {
+		var value string
+		switch e.Type {
+		case EXTRACTOR_COOKIE:
+			value = cookieValue(headers["cookie"], e.Name)
+		case EXTRACTOR_HEADER:
+			value = headers[strings.ToLower(e.Name)]
+		case EXTRACTOR_QUERY:
+			if query.Has(e.Name) {
+				result.RemoveQuery = append(result.RemoveQuery, e.Name)
+			}
+			value = query.Get(e.Name)
+		case EXTRACTOR_JWT:
+			value = jwtClaim(req.GetMetadataContext().GetFilterMetadata()[JWT_AUTHN_METADATA], e.Name)
+		case EXTRACTOR_BAGGAGE:
+			value = baggageValue(headers["baggage"], e.Name)
+		case EXTRACTOR_PATH:
+			var rewritten string
+			if value, rewritten = pathNamespace(e.Name, httpReq.GetPath()); rewritten != "" && result.Path == "" {
+				result.Path = rewritten
+			}
+		case EXTRACTOR_HOST:
+			if result.Authority != "" {
+				continue
+			}
+			namespace, authority := hostNamespace(e, httpReq.GetHost())
+			if namespace != "" && known(namespace) {
+				result.Namespace, result.Extractor, result.Authority = namespace, e, authority
+			}
+			continue
+		}
+		if value != "" && result.Namespace == "" {
+			result.Namespace, result.Extractor = value, e
+		}
+	}
+	return result
+}
+
+// hostNamespace returns the namespace ID in a host (which may include a
+// port) and the canonical host, which drops the label holding it, or for a
+// pattern the labels the submatch spans.
+func hostNamespace(e Extractor, host string) (string, string) {
+	name, port, hasPort := strings.Cut(strings.ToLower(host), ":")
+	var namespace, canonical string
+	switch {
+	case e.Label != nil:
+		labels := strings.Split(name, ".")
+		if *e.Label >= len(labels)-1 {
+			return "", "" // leave at least one label
+		}
+		namespace = labels[*e.Label]
+		canonical = strings.Join(slices.Delete(labels, *e.Label, *e.Label+1), ".")
+	case e.pattern != nil:
+		match := e.pattern.FindStringSubmatchIndex(name)
+		group := max(e.pattern.SubexpIndex(EXTRACTOR_HOST_GROUP), 1)
+		if match == nil || match[2*group] < 0 {
+			return "", ""
+		}
+		start, end := match[2*group], match[2*group+1]
+		namespace = name[start:end]
+		before := name[:strings.LastIndex(name[:start], ".")+1]
+		_, after, _ := strings.Cut(name[end:], ".")
+		canonical = strings.TrimSuffix(before+after, ".")
+	}
+	if namespace == "" || canonical == "" {
+		return "", ""
+	}
+	if hasPort {
+		canonical += ":" + port
+	}
+	return namespace, canonical
+}
+
+// pathNamespace returns the namespace ID in the segment of path following
+// prefix and the path without both, such as "cool-otter" and "/hooks?a=1"
+// for "/_ns/cool-otter/hooks?a=1".
+func pathNamespace(prefix, path string) (string, string) {
+	rest, ok := strings.CutPrefix(path, prefix+"/")
+	if !ok {
+		return "", ""
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	namespace, rest := rest[:end], rest[end:]
+	if namespace == "" {
+		return "", ""
+	}
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	return namespace, rest
+}
+
+// cookieValue returns the value of the named cookie in a Cookie header.
+func cookieValue(header, name string) string {
+	for _, cookie := range strings.Split(header, ";") {
+		cookie = strings.TrimSpace(cookie)
+		if value, ok := strings.CutPrefix(cookie, name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// baggageValue returns the value of the named entry in a W3C baggage
+// header, such as "namespace=cool-otter,userId=alice;private".
+func baggageValue(header, key string) string {
+	for _, member := range strings.Split(header, ",") {
+		member, _, _ = strings.Cut(member, ";") // drop properties
+		k, v, ok := strings.Cut(member, "=")
+		if !ok || strings.TrimSpace(k) != key {
+			continue
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return ""
+		}
+		return value
+	}
+	return ""
+}
+
+// jwtClaim returns a string claim from the payloads of validated JWTs that
+// Envoy's jwt_authn filter put in the metadata, trying the payloads in
+// order of their metadata keys.  Dots in claim separate nested claims.
+func jwtClaim(payloads *structpb.Struct, claim string) string {
+	fields := payloads.GetFields()
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		value := fields[key]
+		for part := range strings.SplitSeq(claim, ".") {
+			value = value.GetStructValue().GetFields()[part]
+		}
+		if s := value.GetStringValue(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// decisionMetadata records which extractor supplied the namespace.
+func decisionMetadata(result extraction) *structpb.Struct {
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		DECISION_METADATA_NAMESPACE: structpb.NewStringValue(result.Namespace),
+		DECISION_METADATA_EXTRACTOR: structpb.NewStringValue(result.Extractor.String()),
+	}}
+}
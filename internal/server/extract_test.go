@@ -0,0 +1,354 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestExtractors(t *testing.T) {
+	const namespaces = `
+namespaces:
+  cool-otter:
+    target: blue
+  awesome-penguin:
+    target: red
+`
+	const chain = `
+server:
+  extractors:
+    - type: query
+    - type: jwt
+      name: env.namespace
+    - type: baggage
+    - type: cookie
+`
+
+	type testCase struct {
+		name      string
+		config    string
+		host      string
+		path      string
+		request   map[string]string
+		jwt       map[string]any
+		target    string
+		extractor string
+		removed   []string
+		authority string
+		rewritten string
+	}
+	tests := []testCase{
+		{
+			name:      "default chain prefers the cookie",
+			config:    namespaces,
+			request:   map[string]string{"cookie": "namespace=cool-otter", "x-namespace": "awesome-penguin"},
+			target:    "blue",
+			extractor: "cookie:namespace",
+		},
+		{
+			name:   "default chain ignores the query",
+			config: namespaces,
+			path:   "/foo?namespace=cool-otter",
+		},
+		{
+			name:      "query parameter",
+			config:    chain + namespaces,
+			path:      "/foo?a=1&namespace=cool-otter",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "query:namespace",
+			removed:   []string{"namespace"},
+		},
+		{
+			name:      "empty query parameter is removed and skipped",
+			config:    chain + namespaces,
+			path:      "/foo?namespace=",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "red",
+			extractor: "cookie:namespace",
+			removed:   []string{"namespace"},
+		},
+		{
+			name:      "JWT claim",
+			config:    chain + namespaces,
+			jwt:       map[string]any{"sub": "alice", "env": map[string]any{"namespace": "cool-otter"}},
+			request:   map[string]string{"baggage": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "jwt:env.namespace",
+		},
+		{
+			name:      "baggage entry",
+			config:    chain + namespaces,
+			request:   map[string]string{"baggage": "userId=alice, namespace = cool%2Dotter;private", "cookie": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "baggage:namespace",
+		},
+		{
+			name: "configured names",
+			config: `
+server:
+  cookieName: env
+  extractors:
+    - type: header
+    - type: query
+      name: ns
+` + namespaces,
+			path:      "/foo?ns=cool-otter",
+			target:    "blue",
+			extractor: "query:ns",
+			removed:   []string{"ns"},
+		},
+		{
+			name:      "subdomain takes precedence over the cookie",
+			config:    "server:\n  extractors:\n    - type: host\n      label: 0\n    - type: cookie\n" + namespaces,
+			host:      "Cool-Otter.envdemo.int.kube:8443",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "host:0",
+			authority: "envdemo.int.kube:8443",
+		},
+		{
+			name:      "subdomain takes precedence wherever it is in the chain",
+			config:    "server:\n  extractors:\n    - type: cookie\n    - type: host\n      label: 0\n" + namespaces,
+			host:      "cool-otter.envdemo.int.kube",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "host:0",
+			authority: "envdemo.int.kube",
+		},
+		{
+			name:      "canonical host falls through to the cookie",
+			config:    "server:\n  extractors:\n    - type: host\n      label: 0\n    - type: cookie\n" + namespaces,
+			host:      "envdemo.int.kube",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "red",
+			extractor: "cookie:namespace",
+		},
+		{
+			name:   "unknown subdomain is ignored",
+			config: "server:\n  extractors:\n    - type: host\n      label: 0\n" + namespaces,
+			host:   "www.envdemo.int.kube",
+		},
+		{
+			name:      "host pattern",
+			config:    "server:\n  extractors:\n    - type: host\n      pattern: '^app-(?P<namespace>[a-z-]+)\\.envdemo\\.int\\.kube$'\n" + namespaces,
+			host:      "app-awesome-penguin.envdemo.int.kube",
+			target:    "red",
+			extractor: `host:^app-(?P<namespace>[a-z-]+)\.envdemo\.int\.kube$`,
+			authority: "envdemo.int.kube",
+		},
+		{
+			name:      "host pattern with a dotted submatch",
+			config:    "server:\n  extractors:\n    - type: host\n      pattern: '^([^.]+)\\.preview\\.'\n" + namespaces,
+			host:      "cool-otter.preview.envdemo.int.kube",
+			target:    "blue",
+			extractor: `host:^([^.]+)\.preview\.`,
+			authority: "preview.envdemo.int.kube",
+		},
+		{
+			name:      "host pattern matching the last label",
+			config:    "server:\n  extractors:\n    - type: host\n      pattern: '^envdemo\\.(?P<namespace>[^.]+)$'\n" + namespaces,
+			host:      "envdemo.cool-otter:8080",
+			target:    "blue",
+			extractor: `host:^envdemo\.(?P<namespace>[^.]+)$`,
+			authority: "envdemo:8080",
+		},
+		{
+			name:      "path prefix",
+			config:    "server:\n  extractors:\n    - type: path\n    - type: cookie\n" + namespaces,
+			path:      "/_ns/cool-otter/hooks/github?a=1",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "blue",
+			extractor: "path:/_ns",
+			rewritten: "/hooks/github?a=1",
+		},
+		{
+			name:      "path prefix without a path",
+			config:    "server:\n  extractors:\n    - type: path\n      name: /env\n" + namespaces,
+			path:      "/env/awesome-penguin?a=1",
+			target:    "red",
+			extractor: "path:/env",
+			rewritten: "/?a=1",
+		},
+		{
+			name:      "path prefix is stripped when another extractor wins",
+			config:    "server:\n  extractors:\n    - type: cookie\n    - type: path\n" + namespaces,
+			path:      "/_ns/cool-otter/hooks",
+			request:   map[string]string{"cookie": "namespace=awesome-penguin"},
+			target:    "red",
+			extractor: "cookie:namespace",
+			rewritten: "/hooks",
+		},
+		{
+			name:   "path prefix without a namespace",
+			config: "server:\n  extractors:\n    - type: path\n" + namespaces,
+			path:   "/_ns//hooks",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewAuthzGRPCServer(newTestHandler(t, tc.config))
+			path := tc.path
+			if path == "" {
+				path = "/"
+			}
+			host := tc.host
+			if host == "" {
+				host = "envdemo.test"
+			}
+			req := newCheckRequest(host, path, tc.request)
+			if tc.jwt != nil {
+				payload, err := structpb.NewStruct(tc.jwt)
+				if err != nil {
+					t.Fatalf("Invalid payload: %v", err)
+				}
+				req.Attributes.MetadataContext = &envoy_config_core_v3.Metadata{
+					FilterMetadata: map[string]*structpb.Struct{
+						JWT_AUTHN_METADATA: {Fields: map[string]*structpb.Value{"jwt_payload": structpb.NewStructValue(payload)}},
+					},
+				}
+			}
+
+			resp, err := server.Check(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.target == "" {
+				if code := codes.Code(resp.GetStatus().GetCode()); code != codes.Unauthenticated {
+					t.Errorf("Expected no namespace, got status %v", code)
+				}
+				return
+			}
+			if target := responseHeaders(resp)["x-backend"]; target != tc.target {
+				t.Errorf("Expected target %q, got %q", tc.target, target)
+			}
+			if extractor := resp.GetDynamicMetadata().GetFields()[DECISION_METADATA_EXTRACTOR].GetStringValue(); extractor != tc.extractor {
+				t.Errorf("Expected extractor %q, got %q", tc.extractor, extractor)
+			}
+			if removed := resp.GetOkResponse().GetQueryParametersToRemove(); !slices.Equal(removed, tc.removed) {
+				t.Errorf("Expected query parameters %v to be removed, got %v", tc.removed, removed)
+			}
+			if authority := responseHeaders(resp)[":authority"]; authority != tc.authority {
+				t.Errorf("Expected authority %q, got %q", tc.authority, authority)
+			}
+			if rewritten := responseHeaders(resp)[":path"]; rewritten != tc.rewritten {
+				t.Errorf("Expected path %q, got %q", tc.rewritten, rewritten)
+			}
+		})
+	}
+}
+
+func TestExtractorSettings(t *testing.T) {
+	type testCase struct {
+		env      string
+		expected string
+	}
+	tests := []testCase{
+		{"", "cookie:env,header:x-env"},
+		{"query, baggage:ns ,jwt", "query:env,baggage:ns,jwt:env"},
+		{"host:1,cookie", "host:1,cookie:env"},
+		{"path,path:/env", "path:/_ns,path:/env"},
+	}
+	for _, tc := range tests {
+		t.Setenv("EXTRACTORS", tc.env)
+		server, err := ServerConfig{CookieName: "env"}.withDefaults()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var got []string
+		for _, e := range server.Extractors {
+			got = append(got, e.String())
+		}
+		if strings.Join(got, ",") != tc.expected {
+			t.Errorf("EXTRACTORS=%q: expected %s, got %s", tc.env, tc.expected, strings.Join(got, ","))
+		}
+	}
+
+	invalid := []struct {
+		extractors string
+		errors     []string
+	}{
+		{"- type: form\n- type: header\n  name: x ns", []string{`"form" is not one of`, "not a valid header name"}},
+		{"- type: host", []string{"either a label or a pattern"}},
+		{"- type: host\n  label: -1", []string{"must not be negative"}},
+		{"- type: host\n  pattern: '[a-z]+'", []string{"needs a submatch"}},
+		{"- type: cookie\n  label: 0", []string{"only apply to host extractors"}},
+		{"- type: path\n  name: _ns/", []string{"must start with a slash"}},
+	}
+	for _, tc := range invalid {
+		cfg, err := ParseConfig([]byte("server:\n  extractors:\n" + strings.ReplaceAll("    "+tc.extractors, "\n", "\n    ") + "\nnamespaces: {}\n"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = cfg.Validate()
+		for _, expected := range tc.errors {
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%q: expected error %q, got %v", tc.extractors, expected, err)
+			}
+		}
+	}
+}
+
+func TestPathPrefixRedirects(t *testing.T) {
+	handler := newTestHandler(t, `
+server:
+  extractors:
+    - type: path
+    - type: cookie
+namespaces:
+  cool-otter:
+    target: blue
+    state: disabled
+`)
+	server := NewAuthzGRPCServer(handler)
+	browser := map[string]string{"accept": "text/html"}
+
+	resp, err := server.Check(context.Background(), newCheckRequest("envdemo.test", "/_ns/", browser))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "http://namespaces.test/?redirect_to=https%3A%2F%2Fenvdemo.test%2F_ns%2F"
+	if location := responseHeaders(resp)["location"]; location != expected {
+		t.Errorf("Expected redirect to %s, got %q", expected, location)
+	}
+
+	resp, err = server.Check(context.Background(), newCheckRequest("envdemo.test", "/_ns/cool-otter/foo", browser))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = "redirect_to=https%3A%2F%2Fenvdemo.test%2F_ns%2Fcool-otter%2Ffoo"
+	if body := resp.GetDeniedResponse().GetBody(); !strings.Contains(body, expected) {
+		t.Errorf("Expected the selector link to keep the prefix, got %q", body)
+	}
+}
+
+func TestSelectorCookieNotExtracted(t *testing.T) {
+	const config = "server:\n  extractors:\n    - type: header\n    - type: cookie\n      name: env\nnamespaces:\n  cool-otter:\n    target: blue\n"
+	cfg, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Server, err = cfg.Server.withDefaults(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	warnings, err := cfg.Validate()
+	if err != nil || !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, `reads the "namespace" cookie`) }) {
+		t.Errorf("Expected a warning about the selector cookie, got %v, %v", warnings, err)
+	}
+
+	// redirecting would loop, as the selector's cookie is never read
+	server := NewAuthzGRPCServer(newTestHandler(t, config))
+	resp, err := server.Check(context.Background(), newCheckRequest("envdemo.test", "/", map[string]string{"accept": "text/html"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status := resp.GetDeniedResponse().GetStatus().GetCode(); status != 401 {
+		t.Errorf("Expected 401 instead of a redirect, got %v", status)
+	}
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configDocument is one source of configuration, such as a file in a
+// conf.d-style directory.
+type configDocument struct {
+	Name string
+	Data []byte
+	// Signature is the detached signature of Data, if there is one.
+	Signature []byte
+}
+
+// readConfigDocuments reads the configuration at path.  A directory is read
+// as a set of fragments: every *.yaml and *.yml file in it, in lexical
+// order.  Hidden entries are skipped, which excludes the ..data and
+// timestamped directories of Kubernetes ConfigMap volumes.  For a
+// directory, the fragments are signed together by the manifest returned
+// alongside them, see fragmentManifest.
+func readConfigDocuments(path string) ([]configDocument, *configDocument, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		signature, err := readSignature(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []configDocument{{Name: filepath.Base(path), Data: data, Signature: signature}}, nil, nil
+	}
+
+	docs, err := readFragments(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := os.ReadFile(filepath.Join(path, CONFIG_MANIFEST_SIGNATURE))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	manifest := &configDocument{Name: filepath.Base(path), Data: fragmentManifest(docs), Signature: signature}
+	return docs, manifest, nil
+}
+
+// readFragments reads the fragments in a directory.
+func readFragments(path string) ([]configDocument, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var docs []configDocument
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !isYAMLFile(name) {
+			continue
+		}
+		// ConfigMap keys are symlinks, so stat rather than use entry.Type()
+		fragmentPath := filepath.Join(path, name)
+		if info, err := os.Stat(fragmentPath); err != nil || info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(fragmentPath)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, configDocument{Name: name, Data: data})
+	}
+	return docs, nil
+}
+
+// fragmentManifest returns the sha256sum(1) listing of fragments, which
+// is what is signed for a directory, so that removing a fragment or
+// replacing it with an older signed one invalidates the signature.
+func fragmentManifest(docs []configDocument) []byte {
+	var manifest bytes.Buffer
+	for _, doc := range docs {
+		fmt.Fprintf(&manifest, "%x  %s\n", sha256.Sum256(doc.Data), doc.Name)
+	}
+	return manifest.Bytes()
+}
+
+// ConfigManifest returns the manifest of the fragments in a directory, to
+// be signed into CONFIG_MANIFEST_SIGNATURE in it.
+func ConfigManifest(path string) ([]byte, error) {
+	docs, err := readFragments(path)
+	if err != nil {
+		return nil, err
+	}
+	return fragmentManifest(docs), nil
+}
+
+func isYAMLFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// configEntry is a key and value defined in a configuration document.
+type configEntry struct {
+	doc        string
+	key, value *yaml.Node
+}
+
+// position returns the document and line the entry was defined at.
+func (e configEntry) position() string {
+	if e.doc == "" {
+		return fmt.Sprintf("line %d", e.key.Line)
+	}
+	return fmt.Sprintf("%s:%d", e.doc, e.key.Line)
+}
+
+// parseConfigDocuments upgrades, interpolates, decodes and merges
+// configuration documents.  Each namespace records the document it came
+// from.  Defining a namespace, template or catalog, or the server settings
+// or defaults, in more than one document is an error.  Documents in an older
+// format version produce a deprecation warning.
+func parseConfigDocuments(docs []configDocument) (AuthzConfig, []string, error) {
+	var cfg AuthzConfig
+	var server, defaults *configEntry
+	namespaces := map[string]configEntry{}
+	templates := map[string]configEntry{}
+	catalogs := map[string]configEntry{}
+	var patterns []configEntry
+	var warnings []string
+	var errs []error
+
+	// single collects a section that may only be defined by one document
+	single := func(doc configDocument, root *yaml.Node, section, what string, previous **configEntry) bool {
+		key, value := mappingEntry(root, section)
+		if key == nil {
+			return false
+		}
+		entry := &configEntry{doc: doc.Name, key: key, value: value}
+		if *previous != nil {
+			errs = append(errs, fmt.Errorf("%s: %s already defined in %s", entry.position(), what, (*previous).position()))
+			return false
+		}
+		*previous = entry
+		return true
+	}
+	// collect collects the entries of a section that documents add to
+	collect := func(doc configDocument, root *yaml.Node, section, what string, into map[string]configEntry) {
+		_, mapping := mappingEntry(root, section)
+		if mapping == nil {
+			return
+		}
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			entry := configEntry{doc: doc.Name, key: mapping.Content[i], value: mapping.Content[i+1]}
+			if previous, ok := into[entry.key.Value]; ok {
+				errs = append(errs, fmt.Errorf("%s: %s %s already defined in %s", entry.position(), what, entry.key.Value, previous.position()))
+				continue
+			}
+			into[entry.key.Value] = entry
+		}
+	}
+
+	for _, doc := range docs {
+		var node yaml.Node
+		if err := yaml.Unmarshal(doc.Data, &node); err != nil {
+			errs = append(errs, documentError(doc.Name, err))
+			continue
+		}
+		if len(node.Content) == 0 {
+			continue // empty document
+		}
+		version, err := migrateNode(&node)
+		if err != nil {
+			errs = append(errs, documentError(doc.Name, err))
+			continue
+		}
+		if version != CONFIG_API_VERSION {
+			warnings = append(warnings, documentError(doc.Name, fmt.Errorf("apiVersion %s is deprecated, upgrade to %s with the migrate command", version, CONFIG_API_VERSION)).Error())
+		}
+		if err := interpolateNode(&node, &cfg.secrets); err != nil {
+			errs = append(errs, documentError(doc.Name, err))
+			continue
+		}
+		var fragment AuthzConfig
+		if err := decodeNode(&node, &fragment); err != nil {
+			errs = append(errs, documentError(doc.Name, err))
+			continue
+		}
+
+		root := node.Content[0]
+		if single(doc, root, "server", "server settings", &server) {
+			cfg.Server = fragment.Server
+		}
+		if single(doc, root, "defaults", "namespace defaults", &defaults) {
+			cfg.Defaults = fragment.Defaults
+		}
+		collect(doc, root, "templates", "template", templates)
+		collect(doc, root, "namespaces", "namespace", namespaces)
+		collect(doc, root, "catalogs", "catalog", catalogs)
+		if _, sequence := mappingEntry(root, "patterns"); sequence != nil && sequence.Kind == yaml.SequenceNode {
+			for i, item := range sequence.Content {
+				p := fragment.Patterns[i]
+				key := &yaml.Node{Kind: yaml.ScalarNode, Value: p.String(), Line: item.Line}
+				patterns = append(patterns, configEntry{doc: doc.Name, key: key, value: item})
+			}
+		}
+		for name, template := range fragment.Templates {
+			if cfg.Templates == nil {
+				cfg.Templates = map[string]NamespaceConfig{}
+			}
+			cfg.Templates[name] = template
+		}
+	}
+
+	used := map[string]bool{}
+	for id, entry := range namespaces {
+		ns, extended, err := resolveNamespace(entry, defaults, templates)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range extended {
+			used[name] = true
+		}
+		ns.Source = entry.doc
+		if cfg.Namespaces == nil {
+			cfg.Namespaces = map[string]NamespaceConfig{}
+		}
+		cfg.Namespaces[id] = ns
+	}
+	for _, entry := range patterns {
+		var p NamespacePattern
+		if err := entry.value.Decode(&p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: pattern %s: %w", entry.position(), entry.key.Value, err))
+			continue
+		}
+		ns, extended, err := resolveNamespace(entry, defaults, templates)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range extended {
+			used[name] = true
+		}
+		ns.Source = entry.doc
+		p.NamespaceConfig = ns
+		_ = p.compile() // checked by Validate
+		cfg.Patterns = append(cfg.Patterns, p)
+	}
+	for key, entry := range catalogs {
+		var catalog CatalogConfig
+		if err := entry.value.Decode(&catalog); err != nil {
+			errs = append(errs, fmt.Errorf("%s: catalog %s: %w", entry.position(), key, err))
+			continue
+		}
+		catalog.Namespaces = nil
+		_, mapping := mappingEntry(resolveAlias(entry.value), "namespaces")
+		for i := 0; mapping != nil && i+1 < len(mapping.Content); i += 2 {
+			nsEntry := configEntry{doc: entry.doc, key: mapping.Content[i], value: mapping.Content[i+1]}
+			ns, extended, err := resolveNamespace(nsEntry, defaults, templates)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, name := range extended {
+				used[name] = true
+			}
+			ns.Source = entry.doc
+			if catalog.Namespaces == nil {
+				catalog.Namespaces = map[string]NamespaceConfig{}
+			}
+			catalog.Namespaces[nsEntry.key.Value] = ns
+		}
+		if cfg.Catalogs == nil {
+			cfg.Catalogs = map[string]CatalogConfig{}
+		}
+		cfg.Catalogs[key] = catalog
+	}
+	for _, name := range slices.Sorted(maps.Keys(templates)) {
+		if !used[name] {
+			warnings = append(warnings, fmt.Sprintf("%s: template %s is not used by any namespace", templates[name].position(), name))
+		}
+	}
+
+	cfg.APIVersion = CONFIG_API_VERSION
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return cfg, warnings, errors.Join(errs...)
+}
+
+// documentError prefixes err with the name of the document it occurred in.
+func documentError(name string, err error) error {
+	if name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
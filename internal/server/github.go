@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults for preview namespaces created from GitHub pull requests
+const (
+	GITHUB_PREVIEW_ID       = "pr-{{.Number}}"
+	GITHUB_PREVIEW_TARGET   = "pr-{{.Number}}"
+	GITHUB_PREVIEW_ON_CLOSE = GITHUB_ON_CLOSE_DELETE
+
+	// GITHUB_ON_CLOSE_DELETE deletes the namespace of a closed pull request,
+	// GITHUB_ON_CLOSE_EXPIRE marks it expired, leaving it to the janitor.
+	GITHUB_ON_CLOSE_DELETE = "delete"
+	GITHUB_ON_CLOSE_EXPIRE = "expire"
+
+	GITHUB_ACTOR        = "github"
+	GITHUB_MAX_PAYLOAD  = 25 << 20 // GitHub caps payloads at 25 MB
+	GITHUB_SIGNATURE_V2 = "sha256="
+)
+
+// GitHubWebhookConfig configures the GitHub pull_request webhook receiver.
+type GitHubWebhookConfig struct {
+	// SecretPath is a file holding the webhook secret.  It is read on every
+	// delivery, so the secret can be rotated without a restart.
+	SecretPath string
+	// IDTemplate and TargetTemplate are text/template templates for the
+	// namespace ID and target of a pull request, see githubPreview.
+	IDTemplate     string
+	TargetTemplate string
+	// OnClose is GITHUB_ON_CLOSE_DELETE or GITHUB_ON_CLOSE_EXPIRE.
+	OnClose string
+}
+
+// githubWebhook is the parsed receiver configuration.
+type githubWebhook struct {
+	secretPath string
+	id, target *template.Template
+	onClose    string
+}
+
+// WithGitHubWebhook enables the GitHub pull_request webhook receiver.
+func WithGitHubWebhook(cfg GitHubWebhookConfig) HandlerOption {
+	return func(h *AuthzHandler) {
+		webhook, err := newGitHubWebhook(cfg)
+		if err != nil {
+			log.Printf("E: GitHub webhook disabled: %v", err)
+			return
+		}
+		h.github = webhook
+	}
+}
+
+func newGitHubWebhook(cfg GitHubWebhookConfig) (*githubWebhook, error) {
+	if cfg.SecretPath == "" {
+		return nil, errors.New("no secret")
+	}
+	if cfg.IDTemplate == "" {
+		cfg.IDTemplate = GITHUB_PREVIEW_ID
+	}
+	if cfg.TargetTemplate == "" {
+		cfg.TargetTemplate = GITHUB_PREVIEW_TARGET
+	}
+	if cfg.OnClose == "" {
+		cfg.OnClose = GITHUB_PREVIEW_ON_CLOSE
+	}
+	if cfg.OnClose != GITHUB_ON_CLOSE_DELETE && cfg.OnClose != GITHUB_ON_CLOSE_EXPIRE {
+		return nil, fmt.Errorf("on close: must be %s or %s, got %q", GITHUB_ON_CLOSE_DELETE, GITHUB_ON_CLOSE_EXPIRE, cfg.OnClose)
+	}
+	id, err := template.New("id").Option("missingkey=error").Parse(cfg.IDTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("namespace ID template: %w", err)
+	}
+	target, err := template.New("target").Option("missingkey=error").Parse(cfg.TargetTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("target template: %w", err)
+	}
+	return &githubWebhook{secretPath: cfg.SecretPath, id: id, target: target, onClose: cfg.OnClose}, nil
+}
+
+// githubPullRequestEvent is the part of a pull_request webhook payload that
+// is used.
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Head    struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// githubPreview holds the values available to the namespace ID and target
+// templates.
+type githubPreview struct {
+	Number     int
+	Title      string
+	Branch     string
+	SHA        string
+	Repository string
+	Owner      string
+}
+
+// verifySignature checks an X-Hub-Signature-256 header against the payload.
+func (w *githubWebhook) verifySignature(payload []byte, signature string) error {
+	secret, err := os.ReadFile(w.secretPath)
+	if err != nil {
+		log.Printf("E: [github] failed to read webhook secret: %v", err)
+		return errors.New("invalid signature")
+	}
+	hexMAC, ok := strings.CutPrefix(signature, GITHUB_SIGNATURE_V2)
+	if !ok {
+		return errors.New("missing signature")
+	}
+	got, err := hex.DecodeString(hexMAC)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, bytes.TrimSpace(secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// render executes a template for a preview.
+func render(t *template.Template, preview githubPreview) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, preview); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PostGitHubWebhookHandler receives GitHub pull_request webhooks and creates,
+// updates or removes the preview namespace of the pull request.
+func (h *AuthzHandler) PostGitHubWebhookHandler(c *gin.Context) {
+	if h.github == nil {
+		c.JSON(http.StatusNotFound, errorResponse("not_found", "the GitHub webhook is not enabled"))
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, GITHUB_MAX_PAYLOAD))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("bad_request", err.Error()))
+		return
+	}
+	if err := h.github.verifySignature(payload, c.GetHeader("X-Hub-Signature-256")); err != nil {
+		c.JSON(http.StatusUnauthorized, errorResponse("unauthorized", err.Error()))
+		return
+	}
+
+	switch event := c.GetHeader("X-GitHub-Event"); event {
+	case "ping":
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+		return
+	case "pull_request":
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "ignored event " + event})
+		return
+	}
+
+	var event githubPullRequestEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("bad_request", err.Error()))
+		return
+	}
+	preview := githubPreview{
+		Number:     event.Number,
+		Title:      event.PullRequest.Title,
+		Branch:     event.PullRequest.Head.Ref,
+		SHA:        event.PullRequest.Head.SHA,
+		Repository: event.Repository.Name,
+		Owner:      event.Repository.Owner.Login,
+	}
+	id, err := render(h.github.id, preview)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("bad_request", err.Error()))
+		return
+	}
+	ctx := c.Request.Context()
+
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	cfg := h.config()
+	previous, exists := h.namespace(ctx, cfg, id)
+	if exists && previous.Source != NAMESPACE_SOURCE_ADMIN {
+		c.JSON(http.StatusConflict, conflict(id, previous.Source))
+		return
+	}
+
+	record := AuditRecord{Actor: GITHUB_ACTOR, Namespace: id}
+	switch event.Action {
+	case "opened", "reopened", "edited", "synchronize":
+		if !exists && (event.Action == "edited" || event.Action == "synchronize") {
+			c.JSON(http.StatusAccepted, gin.H{"message": "no preview namespace " + id})
+			return
+		}
+		target, err := render(h.github.target, preview)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse("bad_request", err.Error()))
+			return
+		}
+		ns := NamespaceConfig{
+			Target:      target,
+			Description: event.PullRequest.Title,
+			Source:      NAMESPACE_SOURCE_ADMIN,
+		}
+		if exists {
+			ns.State, ns.Restricted = previous.State, previous.Restricted
+		}
+		if err := validateManagedNamespace(cfg, id, ns); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse("bad_request", err.Error()))
+			return
+		}
+		if err := h.setManagedNamespace(ctx, id, &ns); err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse("internal", err.Error()))
+			return
+		}
+		record.Action, record.After = "create", &ns
+		if exists {
+			record.Action, record.Before = "update", &previous
+		}
+
+	case "closed":
+		if !exists {
+			c.JSON(http.StatusAccepted, gin.H{"message": "no preview namespace " + id})
+			return
+		}
+		record.Action, record.Before = "delete", &previous
+		if h.github.onClose == GITHUB_ON_CLOSE_EXPIRE {
+			ns := previous
+			now := time.Now().UTC().Truncate(time.Second)
+			ns.ExpiresAt = &now
+			if err := h.setManagedNamespace(ctx, id, &ns); err != nil {
+				c.JSON(http.StatusInternalServerError, errorResponse("internal", err.Error()))
+				return
+			}
+			record.Action, record.After = "expire", &ns
+		} else if err := h.setManagedNamespace(ctx, id, nil); err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse("internal", err.Error()))
+			return
+		}
+
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "ignored action " + event.Action})
+		return
+	}
+
+	h.audit(c, record)
+	c.JSON(http.StatusOK, gin.H{"namespace": id, "action": record.Action})
+}
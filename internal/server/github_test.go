@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const githubSecret = "It's a Secret to Everybody"
+
+// newGitHubRouter serves the webhook of handler, configured with cfg and the
+// secret githubSecret.
+func newGitHubRouter(t *testing.T, handler *AuthzHandler, cfg GitHubWebhookConfig) *gin.Engine {
+	t.Helper()
+	cfg.SecretPath = filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(cfg.SecretPath, []byte(githubSecret+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	WithGitHubWebhook(cfg)(handler)
+	if handler.github == nil {
+		t.Fatal("Expected the GitHub webhook to be enabled")
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler.RegisterRoutes(router)
+	return router
+}
+
+func githubDelivery(router http.Handler, event, payload, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func pullRequestPayload(action string, number int, title string) string {
+	return fmt.Sprintf(`{
+  "action": %q,
+  "number": %d,
+  "pull_request": {"title": %q, "head": {"ref": "feature/x", "sha": "0123456789abcdef"}},
+  "repository": {"name": "envdemo", "full_name": "acme/envdemo", "owner": {"login": "acme"}}
+}`, action, number, title)
+}
+
+func TestGitHubWebhook(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+  pr-7:
+    target: static
+`
+
+	t.Run("signature", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newGitHubRouter(t, handler, GitHubWebhookConfig{})
+		payload := pullRequestPayload("opened", 1234, "Add feature")
+
+		for _, secret := range []string{"", "wrong"} {
+			if rec := githubDelivery(router, "pull_request", payload, secret); rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for secret %q, got %d", secret, rec.Code)
+			}
+		}
+		if _, ok := handler.config().Namespaces["pr-1234"]; ok {
+			t.Error("Expected no namespace from unsigned deliveries")
+		}
+		if rec := githubDelivery(router, "ping", `{"zen": "Keep it logically awesome."}`, githubSecret); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 for ping, got %d", rec.Code)
+		}
+	})
+
+	t.Run("lifecycle", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newGitHubRouter(t, handler, GitHubWebhookConfig{})
+
+		if rec := githubDelivery(router, "pull_request", pullRequestPayload("opened", 1234, "Add feature"), githubSecret); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		ns, ok := handler.config().Namespaces["pr-1234"]
+		if !ok || ns.Target != "pr-1234" || ns.Description != "Add feature" || ns.Source != NAMESPACE_SOURCE_ADMIN {
+			t.Errorf("Expected preview namespace pr-1234, got %+v", ns)
+		}
+
+		githubDelivery(router, "pull_request", pullRequestPayload("edited", 1234, "Add better feature"), githubSecret)
+		if description := handler.config().Namespaces["pr-1234"].Description; description != "Add better feature" {
+			t.Errorf("Expected updated description, got %q", description)
+		}
+		if rec := githubDelivery(router, "pull_request", pullRequestPayload("edited", 99, "Other"), githubSecret); rec.Code != http.StatusAccepted {
+			t.Errorf("Expected 202 for an edit without preview, got %d", rec.Code)
+		}
+
+		if rec := githubDelivery(router, "pull_request", pullRequestPayload("closed", 1234, "Add better feature"), githubSecret); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if _, ok := handler.config().Namespaces["pr-1234"]; ok {
+			t.Error("Expected pr-1234 to be deleted")
+		}
+	})
+
+	t.Run("templates and expiry", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newGitHubRouter(t, handler, GitHubWebhookConfig{
+			IDTemplate:     "{{.Repository}}-{{.Number}}",
+			TargetTemplate: "{{.Repository}}-pr-{{.Number}}",
+			OnClose:        GITHUB_ON_CLOSE_EXPIRE,
+		})
+
+		githubDelivery(router, "pull_request", pullRequestPayload("opened", 42, "Fix bug"), githubSecret)
+		if target := handler.config().Namespaces["envdemo-42"].Target; target != "envdemo-pr-42" {
+			t.Errorf("Expected templated target envdemo-pr-42, got %q", target)
+		}
+		githubDelivery(router, "pull_request", pullRequestPayload("closed", 42, "Fix bug"), githubSecret)
+		ns, ok := handler.config().Namespaces["envdemo-42"]
+		if !ok || !ns.expired(time.Now()) {
+			t.Errorf("Expected envdemo-42 to be expired, got %+v", ns)
+		}
+	})
+
+	t.Run("static namespaces are not touched", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		router := newGitHubRouter(t, handler, GitHubWebhookConfig{})
+
+		for _, action := range []string{"opened", "closed"} {
+			if rec := githubDelivery(router, "pull_request", pullRequestPayload(action, 7, "Static"), githubSecret); rec.Code != http.StatusConflict {
+				t.Errorf("Expected 409 for %s, got %d", action, rec.Code)
+			}
+		}
+		if target := handler.config().Namespaces["pr-7"].Target; target != "static" {
+			t.Errorf("Expected pr-7 to be unchanged, got %s", target)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		handler.RegisterRoutes(router)
+		if rec := githubDelivery(router, "pull_request", pullRequestPayload("opened", 1, "x"), githubSecret); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+}
+
+func TestNewGitHubWebhook(t *testing.T) {
+	type testCase struct {
+		name string
+		cfg  GitHubWebhookConfig
+		err  string
+	}
+	tests := []testCase{
+		{name: "defaults", cfg: GitHubWebhookConfig{SecretPath: "secret"}},
+		{name: "no secret", cfg: GitHubWebhookConfig{}, err: "no secret"},
+		{name: "invalid template", cfg: GitHubWebhookConfig{SecretPath: "secret", IDTemplate: "pr-{{.Number"}, err: "namespace ID template"},
+		{name: "invalid on close", cfg: GitHubWebhookConfig{SecretPath: "secret", OnClose: "archive"}, err: "on close"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newGitHubWebhook(tc.cfg)
+			if tc.err == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("Expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	envoy_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
@@ -28,61 +30,105 @@ func NewAuthzGRPCServer(handler *AuthzHandler) *AuthzGRPCServer {
 
 // Check implements the authorization check
 func (s *AuthzGRPCServer) Check(ctx context.Context, req *envoy_service_auth_v3.CheckRequest) (*envoy_service_auth_v3.CheckResponse, error) {
+	cfg := s.handler.config()
+	resp := s.check(ctx, req, cfg)
+	if cfg.Server.ExposeConfigGeneration {
+		s.addGenerationHeader(resp, cfg.status.Generation)
+	}
+	return resp, nil
+}
+
+// check decides a request against a configuration snapshot
+func (s *AuthzGRPCServer) check(ctx context.Context, req *envoy_service_auth_v3.CheckRequest, cfg AuthzConfig) *envoy_service_auth_v3.CheckResponse {
 	// Extract request information
 	httpReq := req.GetAttributes().GetRequest().GetHttp()
 	if httpReq == nil {
-		return s.denyResponse(codes.InvalidArgument, "missing HTTP request"), nil
+		return s.denyResponse(codes.InvalidArgument, "missing HTTP request")
 	}
+	cfg = cfg.forHost(httpReq.GetHost())
 
-	// Extract namespace from cookie
-	namespaceID := s.GetCookieOrHeader(COOKIE_NAME, httpReq.GetHeaders())
+	// Extract namespace with the configured extractor chain
+	extracted := extractNamespace(cfg.Server.Extractors, req.GetAttributes(), func(id string) bool {
+		_, ok := s.handler.namespaceOrPattern(ctx, cfg, id)
+		return ok
+	})
+	namespaceID := extracted.Namespace
 
-	// If no namespace cookie or header, redirect to namespace selection
+	// Check if this is a browser request or API request.  The original URL
+	// keeps any path prefix, so that redirects return to the same namespace.
+	browser := strings.Contains(httpReq.GetHeaders()["accept"], "text/html")
+	originalURL := fmt.Sprintf("%s://%s%s",
+		httpReq.GetScheme(),
+		httpReq.GetHost(),
+		httpReq.GetPath())
+
+	// If no extractor found a namespace, redirect to namespace selection,
+	// unless the chain cannot read the cookie it sets
 	if namespaceID == "" {
-		// Check if this is a browser request or API request
-		acceptHeader := httpReq.GetHeaders()["accept"]
-		if strings.Contains(acceptHeader, "text/html") {
+		if browser && readsCookie(cfg.Server.Extractors, cfg.selectorCookie()) {
 			// Browser-ish request - redirect to namespace selection page
-			originalURL := fmt.Sprintf("%s://%s%s",
-				httpReq.GetScheme(),
-				httpReq.GetHost(),
-				httpReq.GetPath())
 			redirectURL := fmt.Sprintf("%s?redirect_to=%s",
 				s.handler.PublicURL,
 				url.QueryEscape(originalURL))
 
-			return s.redirectResponse(redirectURL), nil
+			return s.redirectResponse(redirectURL)
 		} else {
 			// API request - return 401 with WWW-Authenticate header
-			return s.unauthorizedResponse("Missing namespace identifier. Provide namespace via 'x-namespace' header or 'namespace' cookie."), nil
+			return s.unauthorizedResponse(cfg.Server.Extractors)
 		}
 	}
+	resp := s.decide(ctx, httpReq.GetHeaders(), cfg, namespaceID, originalURL, browser)
+	resp.DynamicMetadata = decisionMetadata(extracted)
+	if ok := resp.GetOkResponse(); ok != nil {
+		ok.QueryParametersToRemove = extracted.RemoveQuery
+		if extracted.Authority != "" {
+			// so that routes for the canonical host match
+			ok.Headers = append(ok.Headers, &envoy_core_v3.HeaderValueOption{
+				Header: &envoy_core_v3.HeaderValue{Key: ":authority", Value: extracted.Authority},
+			})
+		}
+		if extracted.Path != "" {
+			ok.Headers = append(ok.Headers, &envoy_core_v3.HeaderValueOption{
+				Header: &envoy_core_v3.HeaderValue{Key: ":path", Value: extracted.Path},
+			})
+		}
+	}
+	return resp
+}
 
+// decide checks the namespace supplied with a request
+func (s *AuthzGRPCServer) decide(ctx context.Context, headers map[string]string, cfg AuthzConfig, namespaceID, originalURL string, browser bool) *envoy_service_auth_v3.CheckResponse {
 	// Check if namespace exists in configuration
-	s.handler.configLock.RLock()
-	namespace, ok := s.handler.currentConfig.Namespaces[namespaceID]
-	s.handler.configLock.RUnlock()
-
+	namespace, ok := s.handler.namespaceOrPattern(ctx, cfg, namespaceID)
 	if !ok {
-		return s.denyResponse(codes.PermissionDenied, fmt.Sprintf("unauthorized namespace ID: %v", namespaceID)), nil
+		return s.denyResponse(codes.PermissionDenied, fmt.Sprintf("unauthorized namespace ID: %v", namespaceID))
+	}
+	if namespace.expired(time.Now()) {
+		return s.expiredResponse(namespaceID, *namespace.ExpiresAt)
+	}
+	if namespace.State == NAMESPACE_STATE_DISABLED {
+		return s.disabledResponse(namespaceID, originalURL, browser)
+	}
+	if namespace.Restricted {
+		client := s.GetCookieOrHeader(cfg.Server.CookieName+CLIENT_COOKIE_SUFFIX, headers)
+		if !s.handler.granted(cfg.catalog, namespaceID, client, time.Now()) {
+			return s.denyResponse(codes.PermissionDenied, fmt.Sprintf("restricted namespace %s requires an approved access request", namespaceID))
+		}
 	}
 
-	// Allow request and set x-namespace header
-	return s.allowResponse(namespace.Target), nil
+	// Allow request and set backend header
+	resp := s.allowResponse(cfg.Server.BackendHeader, namespace.Target)
+	if namespace.State == NAMESPACE_STATE_DEPRECATED {
+		s.addDeprecationHeaders(resp, namespaceID)
+	}
+	return resp
 }
 
-// GetCookieOrHeader extracts the namespace value from a cookie or header
+// GetCookieOrHeader extracts a value from the named cookie or, failing
+// that, the x-<name> header
 func (s *AuthzGRPCServer) GetCookieOrHeader(name string, headers map[string]string) string {
-	cookieHeader, ok := headers["cookie"]
-	if ok {
-		// Parse cookies to find namespace
-		cookies := strings.Split(cookieHeader, ";")
-		for _, cookie := range cookies {
-			cookie = strings.TrimSpace(cookie)
-			if after, ok := strings.CutPrefix(cookie, name+"="); ok {
-				return after
-			}
-		}
+	if value := cookieValue(headers["cookie"], name); value != "" {
+		return value
 	}
 
 	if namespace, ok := headers["x-"+name]; ok {
@@ -93,7 +139,7 @@ func (s *AuthzGRPCServer) GetCookieOrHeader(name string, headers map[string]stri
 }
 
 // allowResponse creates a successful authorization response
-func (s *AuthzGRPCServer) allowResponse(namespace string) *envoy_service_auth_v3.CheckResponse {
+func (s *AuthzGRPCServer) allowResponse(header, target string) *envoy_service_auth_v3.CheckResponse {
 	return &envoy_service_auth_v3.CheckResponse{
 		Status: &grpcstatus.Status{Code: int32(codes.OK)},
 		HttpResponse: &envoy_service_auth_v3.CheckResponse_OkResponse{
@@ -101,8 +147,8 @@ func (s *AuthzGRPCServer) allowResponse(namespace string) *envoy_service_auth_v3
 				Headers: []*envoy_core_v3.HeaderValueOption{
 					{
 						Header: &envoy_core_v3.HeaderValue{
-							Key:   "x-backend",
-							Value: namespace,
+							Key:   header,
+							Value: target,
 						},
 					},
 				},
@@ -111,6 +157,23 @@ func (s *AuthzGRPCServer) allowResponse(namespace string) *envoy_service_auth_v3
 	}
 }
 
+// addGenerationHeader adds the configuration generation to the response
+// sent to the client
+func (s *AuthzGRPCServer) addGenerationHeader(resp *envoy_service_auth_v3.CheckResponse, generation int64) {
+	header := &envoy_core_v3.HeaderValueOption{
+		Header: &envoy_core_v3.HeaderValue{
+			Key:   CONFIG_GENERATION_HEADER,
+			Value: strconv.FormatInt(generation, 10),
+		},
+	}
+	switch r := resp.GetHttpResponse().(type) {
+	case *envoy_service_auth_v3.CheckResponse_OkResponse:
+		r.OkResponse.ResponseHeadersToAdd = append(r.OkResponse.ResponseHeadersToAdd, header)
+	case *envoy_service_auth_v3.CheckResponse_DeniedResponse:
+		r.DeniedResponse.Headers = append(r.DeniedResponse.Headers, header)
+	}
+}
+
 // redirectResponse creates a redirect response
 func (s *AuthzGRPCServer) redirectResponse(location string) *envoy_service_auth_v3.CheckResponse {
 	return &envoy_service_auth_v3.CheckResponse{
@@ -147,8 +210,34 @@ func (s *AuthzGRPCServer) denyResponse(code codes.Code, message string) *envoy_s
 	}
 }
 
+// expiredResponse creates a 410 response for a namespace that has expired
+func (s *AuthzGRPCServer) expiredResponse(namespaceID string, expiresAt time.Time) *envoy_service_auth_v3.CheckResponse {
+	message := fmt.Sprintf("environment expired: namespace %s expired at %s", namespaceID, expiresAt.UTC().Format(time.RFC3339))
+	return &envoy_service_auth_v3.CheckResponse{
+		Status: &grpcstatus.Status{
+			Code:    int32(codes.PermissionDenied),
+			Message: message,
+		},
+		HttpResponse: &envoy_service_auth_v3.CheckResponse_DeniedResponse{
+			DeniedResponse: &envoy_service_auth_v3.DeniedHttpResponse{
+				Status: &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode_Gone},
+				Body:   message,
+			},
+		},
+	}
+}
+
 // unauthorizedResponse creates a 401 response for API clients
-func (s *AuthzGRPCServer) unauthorizedResponse(message string) *envoy_service_auth_v3.CheckResponse {
+func (s *AuthzGRPCServer) unauthorizedResponse(extractors []Extractor) *envoy_service_auth_v3.CheckResponse {
+	var ways []string
+	for _, e := range extractors {
+		ways = append(ways, e.describe())
+	}
+	via := strings.Join(ways, " or ")
+	if len(ways) > 2 {
+		via = strings.Join(ways[:len(ways)-1], ", ") + " or " + ways[len(ways)-1]
+	}
+	message := fmt.Sprintf("Missing namespace identifier. Provide namespace via %s.", via)
 	return &envoy_service_auth_v3.CheckResponse{
 		Status: &grpcstatus.Status{
 			Code:    int32(codes.Unauthenticated),
@@ -161,7 +250,7 @@ func (s *AuthzGRPCServer) unauthorizedResponse(message string) *envoy_service_au
 					{
 						Header: &envoy_core_v3.HeaderValue{
 							Key:   "www-authenticate",
-							Value: `Custom realm="namespace-required", error="missing_namespace", error_description="Provide namespace via x-namespace header or namespace cookie"`,
+							Value: fmt.Sprintf(`Custom realm="namespace-required", error="missing_namespace", error_description="Provide namespace via %s"`, via),
 						},
 					},
 				},
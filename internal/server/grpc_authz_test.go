@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+// newTestHandler creates a handler for the given YAML configuration.
+func newTestHandler(t *testing.T, config string, opts ...HandlerOption) *AuthzHandler {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	handler := NewServerHandler("http://namespaces.test/", nil, append([]HandlerOption{WithConfig(configPath)}, opts...)...)
+	if !handler.configLoaded {
+		t.Fatalf("Failed to load test config")
+	}
+	return handler
+}
+
+// newCheckRequest creates an ext_authz check request for a GET request.
+func newCheckRequest(host, path string, headers map[string]string) *envoy_service_auth_v3.CheckRequest {
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+					Method:  "GET",
+					Scheme:  "https",
+					Host:    host,
+					Path:    path,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+// responseHeaders returns the headers set by a check response.
+func responseHeaders(resp *envoy_service_auth_v3.CheckResponse) map[string]string {
+	var options []*envoy_config_core_v3.HeaderValueOption
+	if ok := resp.GetOkResponse(); ok != nil {
+		options = append(ok.GetHeaders(), ok.GetResponseHeadersToAdd()...)
+	} else if denied := resp.GetDeniedResponse(); denied != nil {
+		options = denied.GetHeaders()
+	}
+	headers := map[string]string{}
+	for _, option := range options {
+		headers[option.GetHeader().GetKey()] = option.GetHeader().GetValue()
+	}
+	return headers
+}
+
+func TestCheck(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`
+
+	type testCase struct {
+		name     string
+		config   string
+		host     string
+		path     string
+		request  map[string]string
+		code     codes.Code
+		status   int32
+		response map[string]string
+		body     string
+	}
+
+	tests := []testCase{
+		{
+			name:     "namespace cookie",
+			config:   config,
+			request:  map[string]string{"cookie": "foo=bar; namespace=cool-otter"},
+			code:     codes.OK,
+			response: map[string]string{"x-backend": "blue"},
+		},
+		{
+			name:     "namespace header",
+			config:   config,
+			request:  map[string]string{"x-namespace": "cool-otter"},
+			code:     codes.OK,
+			response: map[string]string{"x-backend": "blue"},
+		},
+		{
+			name:    "unknown namespace",
+			config:  config,
+			request: map[string]string{"x-namespace": "awesome-penguin"},
+			code:    codes.PermissionDenied,
+			status:  403,
+			body:    "unauthorized namespace ID: awesome-penguin",
+		},
+		{
+			name: "expired namespace",
+			config: config + `
+  old-otter:
+    target: green
+    expiresAt: 2020-01-01T00:00:00Z
+`,
+			request: map[string]string{"x-namespace": "old-otter"},
+			code:    codes.PermissionDenied,
+			status:  410,
+			body:    "environment expired: namespace old-otter expired at 2020-01-01T00:00:00Z",
+		},
+		{
+			name: "draining namespace",
+			config: config + `
+  old-otter:
+    target: green
+    state: draining
+`,
+			request:  map[string]string{"x-namespace": "old-otter"},
+			code:     codes.OK,
+			response: map[string]string{"x-backend": "green"},
+		},
+		{
+			name: "disabled namespace",
+			config: config + `
+  old-otter:
+    target: green
+    state: disabled
+`,
+			request:  map[string]string{"x-namespace": "old-otter"},
+			code:     codes.PermissionDenied,
+			status:   403,
+			response: map[string]string{"content-type": "text/plain; charset=utf-8"},
+			body:     "environment disabled: namespace old-otter is disabled",
+		},
+		{
+			name: "disabled namespace in browser",
+			config: config + `
+  old-otter:
+    target: green
+    state: disabled
+`,
+			path:     "/foo",
+			request:  map[string]string{"cookie": "namespace=old-otter", "accept": "text/html"},
+			code:     codes.PermissionDenied,
+			status:   403,
+			response: map[string]string{"content-type": "text/html; charset=utf-8"},
+			body:     `<a href="http://namespaces.test/?redirect_to=https%3A%2F%2Fenvdemo.test%2Ffoo">`,
+		},
+		{
+			name: "deprecated namespace",
+			config: config + `
+  old-otter:
+    target: green
+    state: deprecated
+`,
+			request: map[string]string{"x-namespace": "old-otter"},
+			code:    codes.OK,
+			response: map[string]string{
+				"x-backend":   "green",
+				"deprecation": "true",
+				"warning":     `299 - "namespace old-otter is deprecated, select another one at http://namespaces.test/"`,
+			},
+		},
+		{
+			name:     "browser without namespace",
+			config:   config,
+			host:     "envdemo.test",
+			path:     "/foo",
+			request:  map[string]string{"accept": "text/html"},
+			code:     codes.Unauthenticated,
+			status:   302,
+			response: map[string]string{"location": "http://namespaces.test/?redirect_to=https%3A%2F%2Fenvdemo.test%2Ffoo"},
+		},
+		{
+			name:   "API client without namespace",
+			config: config,
+			code:   codes.Unauthenticated,
+			status: 401,
+			body:   "Provide namespace via 'namespace' cookie or 'x-namespace' header.",
+		},
+		{
+			name: "configured cookie and backend header",
+			config: `
+server:
+  cookieName: env
+  backendHeader: x-env-backend
+` + config,
+			request:  map[string]string{"cookie": "namespace=awesome-penguin; env=cool-otter"},
+			code:     codes.OK,
+			response: map[string]string{"x-env-backend": "blue"},
+		},
+		{
+			name: "configured header",
+			config: `
+server:
+  cookieName: env
+` + config,
+			request:  map[string]string{"x-env": "cool-otter"},
+			code:     codes.OK,
+			response: map[string]string{"x-backend": "blue"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewAuthzGRPCServer(newTestHandler(t, tc.config))
+			host, path := tc.host, tc.path
+			if host == "" {
+				host = "envdemo.test"
+			}
+			if path == "" {
+				path = "/"
+			}
+			resp, err := server.Check(context.Background(), newCheckRequest(host, path, tc.request))
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+
+			if code := codes.Code(resp.GetStatus().GetCode()); code != tc.code {
+				t.Errorf("Expected status %v, got %v", tc.code, code)
+			}
+			if status := int32(resp.GetDeniedResponse().GetStatus().GetCode()); status != tc.status {
+				t.Errorf("Expected HTTP status %d, got %d", tc.status, status)
+			}
+			headers := responseHeaders(resp)
+			for key, value := range tc.response {
+				if headers[key] != value {
+					t.Errorf("Expected header %s: %q, got %q", key, value, headers[key])
+				}
+			}
+			if body := resp.GetDeniedResponse().GetBody(); !strings.Contains(body, tc.body) {
+				t.Errorf("Expected body containing %q, got %q", tc.body, body)
+			}
+		})
+	}
+}
@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"fmt"
 	"log"
+	"maps"
+	"os"
 	"time"
 
 	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
 	"github.com/michaelw/ext-authz-router/api"
 )
 
+// Defaults, each of which can be overridden by the environment variable of
+// the same name.  All but CONFIG_PATH and CONFIG_URL can also be set in the
+// server: section of the configuration.
 const (
 	COOKIE_NAME       = "namespace"
 	COOKIE_DOMAIN     = "int.kube"
@@ -18,9 +25,16 @@ const (
 
 	REDIRECT_URL = "http://namespaces.int.kube/"
 
+	BACKEND_HEADER = "x-backend"
+
+	EXTRACTORS = "cookie,header"
+
 	CONFIG_PATH = "/app/config/config.yaml"
+	CONFIG_URL  = "" // read the configuration from CONFIG_PATH
 )
 
+const CONFIG_RELOAD_DEBOUNCE = 500 * time.Millisecond
+
 var (
 	//go:embed assets/index.html
 	namespaceSelectionSPA string
@@ -33,11 +47,117 @@ func NewServerHandler(publicURL string, swagger *openapi3.T, opts ...HandlerOpti
 		PublicURL:  publicURL,
 		Swagger:    swagger,
 		configPath: CONFIG_PATH,
+		configURL:  CONFIG_URL,
+
+		reloadDebounce: CONFIG_RELOAD_DEBOUNCE,
+		pollInterval:   CONFIG_POLL_INTERVAL,
+
+		janitorInterval: JANITOR_INTERVAL,
+	}
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		handler.configPath = path
+	}
+	if url := os.Getenv("CONFIG_URL"); url != "" {
+		handler.configURL = url
+	}
+	if value := os.Getenv("CONFIG_POLL_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err != nil || interval <= 0 {
+			log.Printf("E: invalid CONFIG_POLL_INTERVAL %q, using %v", value, handler.pollInterval)
+		} else {
+			handler.pollInterval = interval
+		}
+	}
+	if path := os.Getenv("CONFIG_TRUSTED_KEYS"); path != "" {
+		// fail closed: without trusted keys, no configuration loads
+		handler.requireSignature = true
+		keys, err := LoadPublicKeys(path)
+		if err != nil {
+			log.Printf("E: failed to load trusted keys: %v", err)
+		}
+		handler.trustedKeys = keys
+	}
+	if value := os.Getenv("JANITOR_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err != nil || interval <= 0 {
+			log.Printf("E: invalid JANITOR_INTERVAL %q, using %v", value, handler.janitorInterval)
+		} else {
+			handler.janitorInterval = interval
+		}
+	}
+	if path := os.Getenv("ADMIN_TOKENS_PATH"); path != "" {
+		handler.adminTokensPath = path
 	}
+	if path := os.Getenv("ADMIN_STORE_PATH"); path != "" {
+		store, err := OpenNamespaceStore(context.Background(), path)
+		if err != nil {
+			log.Printf("E: failed to open namespace store: %v", err)
+		} else {
+			handler.store = store
+		}
+	}
+	if path := os.Getenv("GITHUB_WEBHOOK_SECRET_PATH"); path != "" {
+		WithGitHubWebhook(GitHubWebhookConfig{
+			SecretPath:     path,
+			IDTemplate:     os.Getenv("GITHUB_PREVIEW_ID"),
+			TargetTemplate: os.Getenv("GITHUB_PREVIEW_TARGET"),
+			OnClose:        os.Getenv("GITHUB_PREVIEW_ON_CLOSE"),
+		})(handler)
+	}
+	if selector := os.Getenv("DISCOVERY_LABEL_SELECTOR"); selector != "" {
+		inClusterDiscovery(DiscoveryConfig{
+			Kind:          os.Getenv("DISCOVERY_KIND"),
+			LabelSelector: selector,
+			Namespace:     os.Getenv("DISCOVERY_NAMESPACE"),
+		})(handler)
+	}
+	if urls := os.Getenv("WEBHOOK_URLS"); urls != "" {
+		WithWebhooks(WebhookConfig{
+			URLs:       splitURLs(urls),
+			SecretPath: os.Getenv("WEBHOOK_SECRET_PATH"),
+		})(handler)
+	}
+	if path := os.Getenv("ADMIN_AUDIT_PATH"); path != "" {
+		auditLog, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Printf("E: failed to open audit log: %v", err)
+		} else {
+			handler.auditLog = auditLog
+		}
+	}
+	// Settings in effect until a configuration is loaded
+	server, err := ServerConfig{}.withDefaults()
+	if err != nil {
+		log.Printf("E: invalid server settings: %v", err)
+	}
+	handler.currentConfig.Server = server
+
 	for _, opt := range opts {
 		opt(handler)
 	}
-	log.Printf("I: configuration file: %v", handler.configPath)
+	if handler.configURL != "" {
+		handler.remote = newRemoteConfig(handler.configURL)
+		handler.remote.signed = handler.requireSignature
+		log.Printf("I: configuration URL: %v", handler.configURL)
+	} else {
+		log.Printf("I: configuration file: %v", handler.configPath)
+	}
+	if handler.requireSignature {
+		log.Printf("I: configuration must be signed by one of %d trusted keys", len(handler.trustedKeys))
+	}
+	if handler.adminTokensPath != "" {
+		log.Printf("I: admin API enabled, tokens: %v", handler.adminTokensPath)
+		if handler.store == nil {
+			log.Printf("W: admin API changes are not persisted, set ADMIN_STORE_PATH to keep them across restarts")
+		}
+	}
+	if handler.github != nil {
+		log.Printf("I: GitHub webhook enabled, secret: %v", handler.github.secretPath)
+		if handler.store == nil {
+			log.Printf("W: preview namespaces are not persisted, set ADMIN_STORE_PATH to keep them across restarts")
+		}
+	}
+	if err := handler.loadManagedNamespaces(); err != nil {
+		log.Printf("E: failed to load namespaces managed through the admin API: %v", err)
+	}
 	if err := handler.loadConfig(); err != nil {
 		log.Printf("E: failed to load configuration: %v", err)
 	}
@@ -60,24 +180,58 @@ func (h *AuthzHandler) Get(ctx context.Context, request api.GetRequestObject) (a
 
 // GetNamespaces handles GET /namespaces - Returns available namespaces
 func (h *AuthzHandler) GetNamespaces(ctx context.Context, request api.GetNamespacesRequestObject) (api.GetNamespacesResponseObject, error) {
-	h.configLock.RLock()
-	namespaces := h.currentConfig.Namespaces
-	h.configLock.RUnlock()
-
 	ns := map[string]api.NamespaceAttributes{}
+	now := time.Now()
+	cfg := h.config().forRedirect(request.Params.RedirectTo, requestHost(ctx))
+	client := h.clientID(ctx, cfg, true)
+	namespaces := h.namespaces(ctx, cfg)
+	if seen := h.seenPatterns(cfg, now); len(seen) > 0 {
+		namespaces = maps.Clone(namespaces)
+		if namespaces == nil {
+			namespaces = map[string]NamespaceConfig{}
+		}
+		for id, attrs := range seen {
+			if _, ok := namespaces[id]; !ok {
+				namespaces[id] = attrs
+			}
+		}
+	}
 	for id, attrs := range namespaces {
+		if attrs.expired(now) || !attrs.selectable() {
+			continue
+		}
 		desc := attrs.Description
 		if desc == "" {
 			desc = id
 		}
-		ns[id] = api.NamespaceAttributes{
+		entry := api.NamespaceAttributes{
 			Description: &desc,
 		}
+		if attrs.State == NAMESPACE_STATE_DEPRECATED {
+			deprecated := true
+			entry.Deprecated = &deprecated
+		}
+		if r, ok := h.reservation(cfg.catalog, id, now); ok {
+			reserved := apiReservation(r, client)
+			entry.Reservation = &reserved
+		}
+		if attrs.Restricted {
+			restricted := true
+			entry.Restricted = &restricted
+			if access, ok := h.access(cfg.catalog, id, client, now); ok {
+				entry.Access = &access
+			}
+		}
+		ns[id] = entry
 	}
 
-	return api.GetNamespaces200JSONResponse{
+	resp := api.GetNamespaces200JSONResponse{
 		Namespaces: ns,
-	}, nil
+	}
+	if title := cfg.title(); title != "" {
+		resp.Title = &title
+	}
+	return resp, nil
 }
 
 // PostSubmit handles POST /namespace - Set namespace cookie
@@ -95,15 +249,35 @@ func (h *AuthzHandler) PostSubmit(ctx context.Context, request api.PostSubmitReq
 		return api.PostSubmit400JSONResponse{}, nil
 	}
 
-	h.configLock.RLock()
-	_, ok := h.currentConfig.Namespaces[namespace]
-	h.configLock.RUnlock()
-
+	cfg := h.config().forRedirect(request.Params.RedirectTo, requestHost(ctx))
+	ns, ok := h.namespaceOrPattern(ctx, cfg, namespace)
 	if !ok {
 		return api.PostSubmit400JSONResponse{}, nil
 	}
+	if ns.expired(time.Now()) {
+		return api.PostSubmit400JSONResponse(errorResponse("expired", fmt.Sprintf("Environment %s expired at %s", namespace, ns.ExpiresAt.Format(time.RFC3339)))), nil
+	}
+	if !ns.selectable() {
+		return api.PostSubmit400JSONResponse(errorResponse(ns.State, fmt.Sprintf("Environment %s is %s", namespace, ns.State))), nil
+	}
+	client := h.clientID(ctx, cfg, false)
+	if ns.Restricted && !h.granted(cfg.catalog, namespace, client, time.Now()) {
+		return api.PostSubmit403JSONResponse(errorResponse("access_required", fmt.Sprintf("Namespace %s is restricted, request access first", namespace))), nil
+	}
+	if r, ok := h.reservation(cfg.catalog, namespace, time.Now()); ok && r.ClientID != client {
+		if r.Exclusive {
+			return api.PostSubmit409JSONResponse(reservedError(namespace, r)), nil
+		}
+		if c, ok := ctx.(*gin.Context); ok {
+			c.Header("Warning", fmt.Sprintf(`299 - "namespace %s is reserved by %s until %s"`, namespace, r.Holder, r.Until.Format(time.RFC3339)))
+		}
+	}
+
+	if ns.fromPattern() {
+		h.seePattern(namespace, time.Now())
+	}
 
-	redirectTo := REDIRECT_URL
+	redirectTo := cfg.Server.RedirectURL
 	if request.Params.RedirectTo != nil {
 		redirectTo = *request.Params.RedirectTo
 	}
@@ -111,7 +285,15 @@ func (h *AuthzHandler) PostSubmit(ctx context.Context, request api.PostSubmitReq
 	return api.PostSubmit302JSONResponse{
 		Headers: api.PostSubmit302ResponseHeaders{
 			Location:  redirectTo,
-			SetCookie: COOKIE_NAME + "=" + namespace + "; Path=/; Domain=" + COOKIE_DOMAIN + "; Expires=" + time.Now().Add(COOKIE_EXPIRATION).UTC().Format(time.RFC1123) + "; HttpOnly", // XXX, also secure
+			SetCookie: cfg.selectorCookie() + "=" + namespace + "; Path=/; Domain=" + cfg.Server.CookieDomain + "; Expires=" + time.Now().Add(cfg.Server.CookieExpiration).UTC().Format(time.RFC1123) + "; HttpOnly", // XXX, also secure
 		},
 	}, nil
 }
+
+// requestHost returns the host a request to the HTTP API was made to.
+func requestHost(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		return c.Request.Host
+	}
+	return ""
+}
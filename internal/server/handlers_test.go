@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+func TestPostSubmit(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`
+
+	t.Run("sets cookie and redirects", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			JSONBody: &api.PostSubmitJSONRequestBody{Value: "cool-otter"},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		redirect, ok := resp.(api.PostSubmit302JSONResponse)
+		if !ok {
+			t.Fatalf("Expected redirect, got %T", resp)
+		}
+		if redirect.Headers.Location != REDIRECT_URL {
+			t.Errorf("Expected redirect to %s, got %s", REDIRECT_URL, redirect.Headers.Location)
+		}
+		if cookie := redirect.Headers.SetCookie; !strings.HasPrefix(cookie, "namespace=cool-otter; Path=/; Domain=int.kube;") {
+			t.Errorf("Unexpected cookie: %s", cookie)
+		}
+	})
+
+	t.Run("uses configured server settings", func(t *testing.T) {
+		handler := newTestHandler(t, `
+server:
+  cookieName: env
+  cookieDomain: example.com
+  redirectURL: https://select.example.com/
+`+config)
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			FormdataBody: &api.PostSubmitFormdataRequestBody{Value: "cool-otter"},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		redirect, ok := resp.(api.PostSubmit302JSONResponse)
+		if !ok {
+			t.Fatalf("Expected redirect, got %T", resp)
+		}
+		if redirect.Headers.Location != "https://select.example.com/" {
+			t.Errorf("Unexpected redirect to %s", redirect.Headers.Location)
+		}
+		if cookie := redirect.Headers.SetCookie; !strings.HasPrefix(cookie, "env=cool-otter; Path=/; Domain=example.com;") {
+			t.Errorf("Unexpected cookie: %s", cookie)
+		}
+	})
+
+	t.Run("environment overrides config", func(t *testing.T) {
+		t.Setenv("COOKIE_DOMAIN", "override.example.com")
+		handler := newTestHandler(t, `
+server:
+  cookieDomain: example.com
+`+config)
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			JSONBody: &api.PostSubmitJSONRequestBody{Value: "cool-otter"},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		redirect, ok := resp.(api.PostSubmit302JSONResponse)
+		if !ok {
+			t.Fatalf("Expected redirect, got %T", resp)
+		}
+		if cookie := redirect.Headers.SetCookie; !strings.Contains(cookie, "; Domain=override.example.com;") {
+			t.Errorf("Unexpected cookie: %s", cookie)
+		}
+	})
+
+	t.Run("unknown namespace", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			JSONBody: &api.PostSubmitJSONRequestBody{Value: "awesome-penguin"},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		if _, ok := resp.(api.PostSubmit400JSONResponse); !ok {
+			t.Errorf("Expected bad request, got %T", resp)
+		}
+	})
+}
+
+func TestNamespaceStates(t *testing.T) {
+	handler := newTestHandler(t, `
+namespaces:
+  cool-otter:
+    target: blue
+  draining-otter:
+    target: green
+    state: draining
+  disabled-otter:
+    target: red
+    state: disabled
+  deprecated-otter:
+    target: yellow
+    state: deprecated
+`)
+
+	resp, err := handler.GetNamespaces(context.Background(), api.GetNamespacesRequestObject{})
+	if err != nil {
+		t.Fatalf("GetNamespaces failed: %v", err)
+	}
+	namespaces := resp.(api.GetNamespaces200JSONResponse).Namespaces
+	var listed []string
+	for id := range namespaces {
+		listed = append(listed, id)
+	}
+	slices.Sort(listed)
+	if strings.Join(listed, ",") != "cool-otter,deprecated-otter" {
+		t.Errorf("Expected cool-otter and deprecated-otter to be listed, got %v", listed)
+	}
+	if deprecated := namespaces["deprecated-otter"].Deprecated; deprecated == nil || !*deprecated {
+		t.Error("Expected deprecated-otter to be marked deprecated")
+	}
+	if namespaces["cool-otter"].Deprecated != nil {
+		t.Error("Expected cool-otter not to be marked deprecated")
+	}
+
+	type testCase struct {
+		namespace string
+		expected  string
+	}
+	tests := []testCase{
+		{"cool-otter", ""},
+		{"deprecated-otter", ""},
+		{"draining-otter", "draining"},
+		{"disabled-otter", "disabled"},
+	}
+	for _, tc := range tests {
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			JSONBody: &api.PostSubmitJSONRequestBody{Value: tc.namespace},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		rejected, ok := resp.(api.PostSubmit400JSONResponse)
+		switch {
+		case tc.expected == "" && ok:
+			t.Errorf("%s: Expected redirect, got %#v", tc.namespace, resp)
+		case tc.expected != "" && (!ok || rejected.Error == nil || *rejected.Error != tc.expected):
+			t.Errorf("%s: Expected 400 %s response, got %#v", tc.namespace, tc.expected, resp)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveNamespace decodes a namespace after deep-merging, in order of
+// increasing precedence, the defaults, the chain of templates it extends and
+// its own settings.  It also returns the names of the templates used.
+func resolveNamespace(entry configEntry, defaults *configEntry, templates map[string]configEntry) (NamespaceConfig, []string, error) {
+	chain := []configEntry{entry}
+	var extended []string
+	for current := entry; ; {
+		_, extends := mappingEntry(resolveAlias(current.value), "extends")
+		if extends == nil || extends.Value == "" {
+			break
+		}
+		name := extends.Value
+		if slices.Contains(extended, name) {
+			return NamespaceConfig{}, nil, fmt.Errorf("%s: namespace %s extends a cycle of templates: %s -> %s", entry.position(), entry.key.Value, strings.Join(extended, " -> "), name)
+		}
+		template, ok := templates[name]
+		if !ok {
+			return NamespaceConfig{}, nil, fmt.Errorf("%s: %s extends unknown template %s", current.position(), current.key.Value, name)
+		}
+		extended = append(extended, name)
+		chain = append(chain, template)
+		current = template
+	}
+
+	var merged *yaml.Node
+	if defaults != nil {
+		merged = withoutKey(defaults.value, "extends")
+	}
+	for _, layer := range slices.Backward(chain) {
+		merged = mergeNodes(merged, withoutKey(layer.value, "extends"))
+	}
+
+	var ns NamespaceConfig
+	if err := merged.Decode(&ns); err != nil {
+		return NamespaceConfig{}, nil, fmt.Errorf("%s: namespace %s: %w", entry.position(), entry.key.Value, err)
+	}
+	if len(extended) > 0 {
+		ns.Extends = extended[0]
+	}
+	return ns, extended, nil
+}
+
+// mergeNodes deep-merges override onto base without modifying either:
+// mappings are merged key by key, any other value in override replaces the
+// one in base.
+func mergeNodes(base, override *yaml.Node) *yaml.Node {
+	base, override = resolveAlias(base), resolveAlias(override)
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+
+	merged := *base
+	merged.Content = slices.Clone(base.Content)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		merged.Content = mergeEntry(merged.Content, key, value)
+	}
+	return &merged
+}
+
+// mergeEntry merges a key and value into the content of a mapping node.
+func mergeEntry(content []*yaml.Node, key, value *yaml.Node) []*yaml.Node {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == key.Value {
+			content[i+1] = mergeNodes(content[i+1], value)
+			return content
+		}
+	}
+	return append(content, key, value)
+}
+
+// withoutKey returns a copy of a mapping node without the given key.
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return node
+	}
+	stripped := *node
+	stripped.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			stripped.Content = append(stripped.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &stripped
+}
+
+// resolveAlias follows YAML aliases to the node they refer to.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
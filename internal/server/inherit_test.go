@@ -0,0 +1,143 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInheritance(t *testing.T) {
+	type testCase struct {
+		name     string
+		config   string
+		expected map[string]NamespaceConfig
+		err      string
+	}
+
+	tests := []testCase{
+		{
+			name: "defaults",
+			config: `
+defaults:
+  description: Preview environment
+namespaces:
+  cool-otter:
+    target: blue
+  awesome-penguin:
+    target: red
+    description: Awesome Penguin
+`,
+			expected: map[string]NamespaceConfig{
+				"cool-otter":      {Target: "blue", Description: "Preview environment"},
+				"awesome-penguin": {Target: "red", Description: "Awesome Penguin"},
+			},
+		},
+		{
+			name: "template chain",
+			config: `
+defaults:
+  target: green
+  description: Preview environment
+templates:
+  blue:
+    target: blue
+  blue-fancy:
+    extends: blue
+    description: Fancy Blue
+namespaces:
+  cool-otter:
+    extends: blue-fancy
+  golden-retriever:
+    extends: blue
+    description: Golden Retriever
+  awesome-penguin: {}
+`,
+			expected: map[string]NamespaceConfig{
+				"cool-otter":       {Extends: "blue-fancy", Target: "blue", Description: "Fancy Blue"},
+				"golden-retriever": {Extends: "blue", Target: "blue", Description: "Golden Retriever"},
+				"awesome-penguin":  {Target: "green", Description: "Preview environment"},
+			},
+		},
+		{
+			name: "unset inherited setting",
+			config: `
+defaults:
+  description: Preview environment
+namespaces:
+  cool-otter:
+    target: blue
+    description: null
+`,
+			expected: map[string]NamespaceConfig{
+				"cool-otter": {Target: "blue"},
+			},
+		},
+		{
+			name: "unknown template",
+			config: `
+namespaces:
+  cool-otter:
+    extends: blue
+`,
+			err: "line 3: cool-otter extends unknown template blue",
+		},
+		{
+			name: "template cycle",
+			config: `
+templates:
+  a:
+    extends: b
+  b:
+    extends: a
+namespaces:
+  cool-otter:
+    extends: a
+`,
+			err: "line 8: namespace cool-otter extends a cycle of templates: a -> b -> a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tc.config))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected error containing %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected config to parse, got: %v", err)
+			}
+
+			if len(cfg.Namespaces) != len(tc.expected) {
+				t.Errorf("Expected %d namespaces, got %d", len(tc.expected), len(cfg.Namespaces))
+			}
+			for id, expected := range tc.expected {
+				if ns := cfg.Namespaces[id]; ns != expected {
+					t.Errorf("Expected namespace %s to resolve to %+v, got %+v", id, expected, ns)
+				}
+			}
+		})
+	}
+}
+
+func TestInheritanceAcrossFragments(t *testing.T) {
+	cfg, warnings, err := parseConfigDocuments([]configDocument{
+		{Name: "base.yaml", Data: []byte("templates:\n  blue:\n    target: blue\n  unused:\n    target: red\n")},
+		{Name: "team.yaml", Data: []byte("namespaces:\n  cool-otter:\n    extends: blue\n")},
+	})
+	if err != nil {
+		t.Fatalf("Expected fragments to parse, got: %v", err)
+	}
+	if ns := cfg.Namespaces["cool-otter"]; ns.Target != "blue" || ns.Source != "team.yaml" {
+		t.Errorf("Expected cool-otter to inherit from base.yaml, got %+v", ns)
+	}
+
+	found := false
+	for _, warning := range warnings {
+		found = found || strings.Contains(warning, "base.yaml:4: template unused is not used by any namespace")
+	}
+	if !found {
+		t.Errorf("Expected warning about unused template, got: %v", warnings)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	referencePattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	envNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// interpolateNode expands references in the scalar values (not keys) of a
+// YAML document:
+//
+//	${NAME}             value of environment variable NAME
+//	${NAME:-default}    value of NAME, or default if NAME is unset or empty
+//	${file:/some/path}  contents of a file, without the trailing newline
+//	$${                 a literal ${
+//
+// A reference that cannot be resolved is an error rather than an empty
+// string.  Values that include file contents are added to secrets.
+func interpolateNode(node *yaml.Node, secrets *[]string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if strings.Contains(node.Value, "${file:") {
+			*secrets = append(*secrets, value)
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style == 0 {
+				node.Tag = "" // let plain scalars resolve to their expanded type
+			}
+		}
+		return nil
+	case yaml.MappingNode:
+		var errs []error
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, interpolateNode(node.Content[i], secrets))
+		}
+		return errors.Join(errs...)
+	case yaml.DocumentNode, yaml.SequenceNode:
+		var errs []error
+		for _, child := range node.Content {
+			errs = append(errs, interpolateNode(child, secrets))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// interpolate expands the references in s.
+func interpolate(s string) (string, error) {
+	var errs []error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		value, err := resolveReference(match[2 : len(match)-1])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", match, err))
+		}
+		return value
+	})
+	return expanded, errors.Join(errs...)
+}
+
+// resolveReference returns the value of a single reference, given without
+// the surrounding ${ }.
+func resolveReference(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasDefault := strings.Cut(ref, ":-")
+	if !envNamePattern.MatchString(name) {
+		return "", errors.New("invalid reference")
+	}
+	value, ok := os.LookupEnv(name)
+	switch {
+	case hasDefault && value == "":
+		return fallback, nil
+	case !ok:
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(secretPath, []byte("from-file\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	t.Setenv("AUTHZ_TEST_SUFFIX", "east")
+	t.Setenv("AUTHZ_TEST_EMPTY", "")
+
+	type testCase struct {
+		name     string
+		input    string
+		expected string
+		err      string
+	}
+
+	tests := []testCase{
+		{name: "no references", input: "blue", expected: "blue"},
+		{name: "environment variable", input: "blue-${AUTHZ_TEST_SUFFIX}", expected: "blue-east"},
+		{name: "default for unset variable", input: "${AUTHZ_TEST_UNSET:-west}", expected: "west"},
+		{name: "default for empty variable", input: "${AUTHZ_TEST_EMPTY:-west}", expected: "west"},
+		{name: "default not used", input: "${AUTHZ_TEST_SUFFIX:-west}", expected: "east"},
+		{name: "empty variable", input: "blue${AUTHZ_TEST_EMPTY}", expected: "blue"},
+		{name: "file reference", input: "${file:" + secretPath + "}", expected: "from-file"},
+		{name: "escaped reference", input: "$${AUTHZ_TEST_SUFFIX}", expected: "${AUTHZ_TEST_SUFFIX}"},
+		{name: "unset variable", input: "${AUTHZ_TEST_UNSET}", err: "${AUTHZ_TEST_UNSET}: environment variable AUTHZ_TEST_UNSET is not set"},
+		{name: "missing file", input: "${file:/does/not/exist}", err: "no such file or directory"},
+		{name: "invalid reference", input: "${not valid}", err: "${not valid}: invalid reference"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := interpolate(tc.input)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected error containing %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected %q to interpolate, got: %v", tc.input, err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseConfigInterpolation(t *testing.T) {
+	t.Setenv("AUTHZ_TEST_CLUSTER", "east")
+
+	cfg, err := ParseConfig([]byte(`
+namespaces:
+  cool-otter:
+    target: blue-${AUTHZ_TEST_CLUSTER}
+    description: Cool Otter (${AUTHZ_TEST_REGION:-us})
+`))
+	if err != nil {
+		t.Fatalf("Expected config to parse, got: %v", err)
+	}
+	if ns := cfg.Namespaces["cool-otter"]; ns.Target != "blue-east" || ns.Description != "Cool Otter (us)" {
+		t.Errorf("Expected interpolated namespace, got %+v", ns)
+	}
+
+	_, err = ParseConfig([]byte(`
+namespaces:
+  cool-otter:
+    target: ${AUTHZ_TEST_UNSET}
+`))
+	expected := "line 4: ${AUTHZ_TEST_UNSET}: environment variable AUTHZ_TEST_UNSET is not set"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got: %v", expected, err)
+	}
+}
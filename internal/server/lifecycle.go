@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+
+	envoy_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	grpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// Namespace lifecycle states.  An empty state is active.
+const (
+	// NAMESPACE_STATE_ACTIVE namespaces are offered and routed to.
+	NAMESPACE_STATE_ACTIVE = "active"
+	// NAMESPACE_STATE_DRAINING namespaces are no longer offered, but
+	// existing cookies keep routing to them.
+	NAMESPACE_STATE_DRAINING = "draining"
+	// NAMESPACE_STATE_DISABLED namespaces are not routed to; users are
+	// pointed back to the selector.
+	NAMESPACE_STATE_DISABLED = "disabled"
+	// NAMESPACE_STATE_DEPRECATED namespaces are routed to, with a warning.
+	NAMESPACE_STATE_DEPRECATED = "deprecated"
+)
+
+var namespaceStates = []string{
+	NAMESPACE_STATE_ACTIVE,
+	NAMESPACE_STATE_DRAINING,
+	NAMESPACE_STATE_DISABLED,
+	NAMESPACE_STATE_DEPRECATED,
+}
+
+var (
+	//go:embed assets/disabled.html
+	disabledPageHTML string
+	disabledPage     = template.Must(template.New("disabled").Parse(disabledPageHTML))
+)
+
+// validateState checks that state is a known lifecycle state.
+func validateState(state string) error {
+	if state != "" && !slices.Contains(namespaceStates, state) {
+		return fmt.Errorf("unknown state %q, must be one of %s", state, strings.Join(namespaceStates, ", "))
+	}
+	return nil
+}
+
+// selectable reports whether the namespace is offered in the selector and
+// can be chosen through PostSubmit.
+func (ns NamespaceConfig) selectable() bool {
+	return ns.State != NAMESPACE_STATE_DRAINING && ns.State != NAMESPACE_STATE_DISABLED
+}
+
+// disabledResponse creates a 403 response for a disabled namespace.  Browsers
+// get a page linking back to the selector, which then returns them to
+// originalURL.
+func (s *AuthzGRPCServer) disabledResponse(namespaceID, originalURL string, html bool) *envoy_service_auth_v3.CheckResponse {
+	message := fmt.Sprintf("environment disabled: namespace %s is disabled, select another one at %s", namespaceID, s.handler.PublicURL)
+	body, contentType := message, "text/plain; charset=utf-8"
+	if html {
+		var buf bytes.Buffer
+		err := disabledPage.Execute(&buf, map[string]string{
+			"Namespace":   namespaceID,
+			"SelectorURL": s.handler.PublicURL + "?redirect_to=" + url.QueryEscape(originalURL),
+		})
+		if err != nil {
+			log.Printf("E: rendering disabled page: %v", err)
+		} else {
+			body, contentType = buf.String(), "text/html; charset=utf-8"
+		}
+	}
+	return &envoy_service_auth_v3.CheckResponse{
+		Status: &grpcstatus.Status{
+			Code:    int32(codes.PermissionDenied),
+			Message: message,
+		},
+		HttpResponse: &envoy_service_auth_v3.CheckResponse_DeniedResponse{
+			DeniedResponse: &envoy_service_auth_v3.DeniedHttpResponse{
+				Status: &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode_Forbidden},
+				Headers: []*envoy_core_v3.HeaderValueOption{
+					{
+						Header: &envoy_core_v3.HeaderValue{
+							Key:   "content-type",
+							Value: contentType,
+						},
+					},
+				},
+				Body: body,
+			},
+		},
+	}
+}
+
+// addDeprecationHeaders adds Deprecation and Warning headers to the response
+// sent to the client
+func (s *AuthzGRPCServer) addDeprecationHeaders(resp *envoy_service_auth_v3.CheckResponse, namespaceID string) {
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		return
+	}
+	ok.ResponseHeadersToAdd = append(ok.ResponseHeadersToAdd,
+		&envoy_core_v3.HeaderValueOption{
+			Header: &envoy_core_v3.HeaderValue{Key: "deprecation", Value: "true"},
+		},
+		&envoy_core_v3.HeaderValueOption{
+			Header: &envoy_core_v3.HeaderValue{
+				Key:   "warning",
+				Value: fmt.Sprintf(`299 - "namespace %s is deprecated, select another one at %s"`, namespaceID, s.handler.PublicURL),
+			},
+		},
+	)
+}
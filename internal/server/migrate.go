@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration format versions.  Documents without an apiVersion are
+// treated as CONFIG_API_VERSION_V1ALPHA1, the format before versioning.
+const (
+	CONFIG_API_VERSION_V1ALPHA1 = "ext-authz-router/v1alpha1"
+	CONFIG_API_VERSION_V1       = "ext-authz-router/v1"
+
+	CONFIG_API_VERSION = CONFIG_API_VERSION_V1
+)
+
+// configMigration upgrades a document from one format version to the next.
+type configMigration struct {
+	from, to string
+	convert  func(doc *yaml.Node) error
+}
+
+// configMigrations form a chain from the oldest supported version to
+// CONFIG_API_VERSION.
+var configMigrations = []configMigration{
+	{
+		// v1 is the v1alpha1 format with a mandatory version marker
+		from:    CONFIG_API_VERSION_V1ALPHA1,
+		to:      CONFIG_API_VERSION_V1,
+		convert: func(doc *yaml.Node) error { return nil },
+	},
+}
+
+// migrateNode upgrades a configuration document in place to
+// CONFIG_API_VERSION and returns the version it was written in.
+func migrateNode(node *yaml.Node) (string, error) {
+	doc := node
+	if doc.Kind == yaml.DocumentNode {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return "", nil // reported by Decode
+	}
+
+	key, value := mappingEntry(doc, "apiVersion")
+	version := CONFIG_API_VERSION_V1ALPHA1
+	if value != nil {
+		version = value.Value
+	}
+	original := version
+
+	for _, migration := range configMigrations {
+		if version != migration.from {
+			continue
+		}
+		if err := migration.convert(doc); err != nil {
+			return original, fmt.Errorf("migrating from %s to %s: %w", migration.from, migration.to, err)
+		}
+		version = migration.to
+	}
+	if version != CONFIG_API_VERSION {
+		line := doc.Line
+		if key != nil {
+			line = key.Line
+		}
+		return original, fmt.Errorf("line %d: unsupported apiVersion %q", line, original)
+	}
+
+	if value == nil {
+		key = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "apiVersion"}
+		value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+		if len(doc.Content) > 0 {
+			// keep a leading comment at the top of the document
+			key.HeadComment, doc.Content[0].HeadComment = doc.Content[0].HeadComment, ""
+		}
+		doc.Content = append([]*yaml.Node{key, value}, doc.Content...)
+	}
+	value.Value = version
+	return original, nil
+}
+
+// MigrateConfig rewrites a configuration document in the current format
+// version.  Comments and ${...} references are preserved.  It reports
+// whether the document was changed.
+func MigrateConfig(data []byte) ([]byte, bool, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, false, err
+	}
+	if len(node.Content) == 0 {
+		return data, false, nil
+	}
+	version, err := migrateNode(&node)
+	if err != nil {
+		return nil, false, err
+	}
+	if version == CONFIG_API_VERSION {
+		return data, false, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, false, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
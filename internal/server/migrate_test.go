@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	t.Run("unversioned document", func(t *testing.T) {
+		migrated, changed, err := MigrateConfig([]byte(`# routing for the demo
+namespaces:
+  cool-otter:
+    target: ${TARGET:-blue} # overridden per cluster
+`))
+		if err != nil {
+			t.Fatalf("Expected migration to succeed, got: %v", err)
+		}
+		if !changed {
+			t.Errorf("Expected unversioned document to be changed")
+		}
+		expected := `# routing for the demo
+apiVersion: ext-authz-router/v1
+namespaces:
+  cool-otter:
+    target: ${TARGET:-blue} # overridden per cluster
+`
+		if string(migrated) != expected {
+			t.Errorf("Expected:\n%s\ngot:\n%s", expected, migrated)
+		}
+	})
+
+	t.Run("current document", func(t *testing.T) {
+		original := "apiVersion: ext-authz-router/v1\nnamespaces: {}\n"
+		migrated, changed, err := MigrateConfig([]byte(original))
+		if err != nil {
+			t.Fatalf("Expected migration to succeed, got: %v", err)
+		}
+		if changed || string(migrated) != original {
+			t.Errorf("Expected current document to be unchanged, got:\n%s", migrated)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		_, _, err := MigrateConfig([]byte("namespaces: {}\napiVersion: ext-authz-router/v9\n"))
+		expected := `line 2: unsupported apiVersion "ext-authz-router/v9"`
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got: %v", expected, err)
+		}
+	})
+}
+
+func TestLoadConfigDeprecatedVersion(t *testing.T) {
+	handler := newTestHandler(t, "namespaces:\n  cool-otter:\n    target: blue\n    description: Cool Otter\n")
+	cfg, warnings, err := LoadConfig(handler.configPath)
+	if err != nil {
+		t.Fatalf("Expected config to load, got: %v", err)
+	}
+	if cfg.APIVersion != CONFIG_API_VERSION {
+		t.Errorf("Expected config to be upgraded to %s, got %s", CONFIG_API_VERSION, cfg.APIVersion)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "apiVersion ext-authz-router/v1alpha1 is deprecated") {
+		t.Errorf("Expected deprecation warning, got: %v", warnings)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"text/template"
+	"time"
+)
+
+// The selector offers namespaces matched by patterns for PATTERN_SEEN_TTL
+// after they were last selected, up to the PATTERN_SEEN_MAX most recent.
+const (
+	PATTERN_SEEN_TTL = 24 * time.Hour
+	PATTERN_SEEN_MAX = 100
+)
+
+// PATTERN_ID_KEY is the key of the namespace ID in the data that pattern
+// templates are rendered with, alongside the named submatches.
+const PATTERN_ID_KEY = "id"
+
+// NamespacePattern defines the namespaces whose IDs match a glob or a
+// regular expression, which must match the whole ID.  Target and
+// Description are text/template templates rendered with the named
+// submatches and the ID, e.g. preview-{{.num}} for pr-(?P<num>\d+).
+type NamespacePattern struct {
+	Glob            string `yaml:"glob,omitempty" json:"glob,omitempty"`
+	Regex           string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	NamespaceConfig `yaml:",inline"`
+
+	regex               *regexp.Regexp
+	target, description *template.Template
+}
+
+// String returns the glob or regular expression.
+func (p NamespacePattern) String() string {
+	if p.Regex != "" {
+		return p.Regex
+	}
+	return p.Glob
+}
+
+// compile parses the regular expression and templates of a pattern.
+func (p *NamespacePattern) compile() error {
+	var errs []error
+	switch {
+	case (p.Glob == "") == (p.Regex == ""):
+		errs = append(errs, errors.New("needs either a glob or a regex"))
+	case p.Glob != "":
+		if _, err := path.Match(p.Glob, ""); err != nil {
+			errs = append(errs, fmt.Errorf("glob: %w", err))
+		}
+	default:
+		regex, err := regexp.Compile(`^(?:` + p.Regex + `)$`)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("regex: %w", err))
+		}
+		p.regex = regex
+	}
+	var err error
+	if p.target, err = template.New("target").Option("missingkey=error").Parse(p.Target); err != nil {
+		errs = append(errs, fmt.Errorf("target: %w", err))
+	}
+	if p.description, err = template.New("description").Option("missingkey=error").Parse(p.Description); err != nil {
+		errs = append(errs, fmt.Errorf("description: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the template data for id, if it matches the pattern.
+func (p NamespacePattern) match(id string) (map[string]string, bool) {
+	data := map[string]string{PATTERN_ID_KEY: id}
+	if p.regex == nil {
+		ok, _ := path.Match(p.Glob, id)
+		return data, ok && p.Glob != ""
+	}
+	submatches := p.regex.FindStringSubmatch(id)
+	if submatches == nil {
+		return nil, false
+	}
+	for i, name := range p.regex.SubexpNames() {
+		if name != "" {
+			data[name] = submatches[i]
+		}
+	}
+	return data, true
+}
+
+// render returns the namespace for an ID matching the pattern.
+func (p NamespacePattern) render(data map[string]string) (NamespaceConfig, error) {
+	ns := p.NamespaceConfig
+	ns.pattern = p.String()
+	for _, field := range []struct {
+		t   *template.Template
+		out *string
+	}{{p.target, &ns.Target}, {p.description, &ns.Description}} {
+		if field.t == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := field.t.Execute(&buf, data); err != nil {
+			return NamespaceConfig{}, err
+		}
+		*field.out = buf.String()
+	}
+	if err := validateHeaderValue(ns.Target); err != nil {
+		return NamespaceConfig{}, fmt.Errorf("target %q: %w", ns.Target, err)
+	}
+	return ns, nil
+}
+
+// validatePatterns checks the patterns, rendering each with empty
+// submatches to find references to unknown ones.
+func validatePatterns(patterns []NamespacePattern) []error {
+	var errs []error
+	for i, p := range patterns {
+		path := fmt.Sprintf("patterns[%d]", i)
+		if err := p.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if p.Target == "" {
+			errs = append(errs, fmt.Errorf("%s.target: must not be empty", path))
+			continue
+		}
+		if err := validateState(p.State); err != nil {
+			errs = append(errs, fmt.Errorf("%s.state: %w", path, err))
+		}
+		data := map[string]string{PATTERN_ID_KEY: ""}
+		if p.regex != nil {
+			for _, name := range p.regex.SubexpNames() {
+				if name != "" {
+					data[name] = ""
+				}
+			}
+		}
+		for _, t := range []*template.Template{p.target, p.description} {
+			if err := t.Execute(&bytes.Buffer{}, data); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", path, t.Name(), err))
+			}
+		}
+	}
+	return errs
+}
+
+// matchPattern returns the namespace for id from the first pattern in cfg
+// that matches it.
+func (cfg AuthzConfig) matchPattern(id string) (NamespaceConfig, bool) {
+	if validateNamespaceID(id) != nil {
+		return NamespaceConfig{}, false
+	}
+	for _, p := range cfg.Patterns {
+		data, ok := p.match(id)
+		if !ok {
+			continue
+		}
+		ns, err := p.render(data)
+		if err != nil {
+			log.Printf("E: pattern %s: namespace %s: %v", p, id, err)
+			return NamespaceConfig{}, false
+		}
+		return ns, true
+	}
+	return NamespaceConfig{}, false
+}
+
+// fromPattern reports whether the namespace was matched by a pattern.
+func (ns NamespaceConfig) fromPattern() bool {
+	return ns.pattern != ""
+}
+
+// namespaceOrPattern looks up a namespace like namespace and, failing
+// that, matches it against the patterns, so that namespaces defined
+// explicitly take precedence.
+func (h *AuthzHandler) namespaceOrPattern(ctx context.Context, cfg AuthzConfig, id string) (NamespaceConfig, bool) {
+	if ns, ok := h.namespace(ctx, cfg, id); ok {
+		return ns, true
+	}
+	return cfg.matchPattern(id)
+}
+
+// seePattern records that a namespace matched by a pattern was selected, so
+// that the selector offers it.  Beyond PATTERN_SEEN_MAX, the namespace
+// selected least recently is forgotten.
+func (h *AuthzHandler) seePattern(id string, now time.Time) {
+	h.patternLock.Lock()
+	defer h.patternLock.Unlock()
+	if h.patternsSeen == nil {
+		h.patternsSeen = map[string]time.Time{}
+	}
+	h.patternsSeen[id] = now
+	if len(h.patternsSeen) > PATTERN_SEEN_MAX {
+		oldest := id
+		for seenID, seen := range h.patternsSeen {
+			if seen.Before(h.patternsSeen[oldest]) {
+				oldest = seenID
+			}
+		}
+		delete(h.patternsSeen, oldest)
+	}
+}
+
+// purgePatterns forgets the namespaces matched by patterns that were not
+// selected within PATTERN_SEEN_TTL of now, and returns their IDs.
+func (h *AuthzHandler) purgePatterns(now time.Time) []string {
+	h.patternLock.Lock()
+	defer h.patternLock.Unlock()
+	var purged []string
+	for _, id := range slices.Sorted(maps.Keys(h.patternsSeen)) {
+		if now.Sub(h.patternsSeen[id]) > PATTERN_SEEN_TTL {
+			purged = append(purged, id)
+			delete(h.patternsSeen, id)
+		}
+	}
+	return purged
+}
+
+// seenPatterns returns the namespaces matched by patterns that were
+// selected within PATTERN_SEEN_TTL and still match.
+func (h *AuthzHandler) seenPatterns(cfg AuthzConfig, now time.Time) map[string]NamespaceConfig {
+	h.patternLock.Lock()
+	var ids []string
+	for id, seen := range h.patternsSeen {
+		if now.Sub(seen) <= PATTERN_SEEN_TTL {
+			ids = append(ids, id)
+		}
+	}
+	h.patternLock.Unlock()
+
+	namespaces := map[string]NamespaceConfig{}
+	for _, id := range ids {
+		if ns, ok := cfg.matchPattern(id); ok {
+			namespaces[id] = ns
+		}
+	}
+	return namespaces
+}
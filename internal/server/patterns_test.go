@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelw/ext-authz-router/api"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPatterns(t *testing.T) {
+	handler := newTestHandler(t, `
+templates:
+  feature:
+    description: Feature branch {{.id}}
+namespaces:
+  pr-1:
+    target: release
+    description: Release candidate
+patterns:
+  - regex: pr-(?P<num>\d+)
+    target: preview-{{.num}}
+    description: 'Pull request #{{.num}}'
+  - glob: feature-*
+    extends: feature
+    target: features
+`)
+	server := NewAuthzGRPCServer(handler)
+
+	type testCase struct {
+		namespace string
+		target    string
+	}
+	tests := []testCase{
+		{"pr-42", "preview-42"},
+		{"pr-1", "release"},
+		{"feature-login", "features"},
+		{"pr-x", ""},
+		{"xpr-42", ""},
+	}
+	for _, tc := range tests {
+		resp, err := server.Check(context.Background(), newCheckRequest("envdemo.test", "/", map[string]string{"x-namespace": tc.namespace}))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if tc.target == "" {
+			if code := codes.Code(resp.GetStatus().GetCode()); code != codes.PermissionDenied {
+				t.Errorf("%s: expected permission denied, got %v", tc.namespace, code)
+			}
+			continue
+		}
+		if target := responseHeaders(resp)["x-backend"]; target != tc.target {
+			t.Errorf("%s: expected target %q, got %q", tc.namespace, tc.target, target)
+		}
+	}
+
+	for _, id := range []string{"pr-7", "feature-login"} {
+		resp, err := handler.PostSubmit(context.Background(), api.PostSubmitRequestObject{
+			JSONBody: &api.PostSubmitJSONRequestBody{Value: id},
+		})
+		if err != nil {
+			t.Fatalf("PostSubmit failed: %v", err)
+		}
+		if _, ok := resp.(api.PostSubmit302JSONResponse); !ok {
+			t.Errorf("Expected %s to be accepted, got %T", id, resp)
+		}
+	}
+
+	list, err := handler.GetNamespaces(context.Background(), api.GetNamespacesRequestObject{})
+	if err != nil {
+		t.Fatalf("GetNamespaces failed: %v", err)
+	}
+	namespaces := list.(api.GetNamespaces200JSONResponse).Namespaces
+	var ids []string
+	for id := range namespaces {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if expected := []string{"feature-login", "pr-1", "pr-7"}; !slices.Equal(ids, expected) {
+		t.Errorf("Expected the namespaces selected recently %v, got %v", expected, ids)
+	}
+	for id, expected := range map[string]string{"pr-7": "Pull request #7", "feature-login": "Feature branch feature-login"} {
+		if desc := namespaces[id].Description; desc == nil || *desc != expected {
+			t.Errorf("%s: expected description %q, got %v", id, expected, desc)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	type testCase struct {
+		pattern string
+		err     string
+	}
+	tests := []testCase{
+		{"regex: pr-(?P<num>\\d+)\n    target: preview-{{.number}}", `map has no entry for key "number"`},
+		{"regex: pr-(\n    target: preview", "patterns[0]: regex:"},
+		{"glob: pr-*\n    regex: pr-.*\n    target: preview", "needs either a glob or a regex"},
+		{"glob: pr-*", "patterns[0].target: must not be empty"},
+		{"glob: pr-*\n    target: '{{.id'", "patterns[0]: target:"},
+	}
+	for _, tc := range tests {
+		cfg, err := ParseConfig([]byte("namespaces: {}\npatterns:\n  - " + tc.pattern + "\n"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error %q, got %v", tc.pattern, tc.err, err)
+		}
+	}
+}
+
+func TestSeenPatterns(t *testing.T) {
+	handler := newTestHandler(t, "namespaces: {}\npatterns:\n  - glob: feature-*\n    target: features\n")
+	cfg := handler.config()
+	now := time.Now()
+	for i := range PATTERN_SEEN_MAX + 1 {
+		handler.seePattern(fmt.Sprintf("feature-%d", i), now.Add(time.Duration(i)*time.Second))
+	}
+	seen := handler.seenPatterns(cfg, now.Add(PATTERN_SEEN_MAX*time.Second))
+	if _, ok := seen["feature-0"]; ok || len(seen) != PATTERN_SEEN_MAX {
+		t.Errorf("Expected the %d most recent namespaces, got %d", PATTERN_SEEN_MAX, len(seen))
+	}
+
+	purged := handler.purgePatterns(now.Add(PATTERN_SEEN_TTL + 50*time.Second))
+	if len(purged) != 49 || purged[0] != "feature-1" {
+		t.Errorf("Expected feature-1 to feature-49 to be purged, got %v", purged)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+// errPromotionChanged is returned when rolling back a promotion whose
+// namespaces were changed since.
+var errPromotionChanged = errors.New("was changed after the promotion")
+
+// promotion is a target promotion whose automatic rollback is pending.
+type promotion struct {
+	api.Promotion
+	timer *time.Timer
+}
+
+// CreateAdminPromotion handles POST /admin/promotions - Copy the target of
+// one namespace to another, or swap their targets, as one generation
+func (h *AuthzHandler) CreateAdminPromotion(ctx context.Context, request api.CreateAdminPromotionRequestObject) (api.CreateAdminPromotionResponseObject, error) {
+	from, to := request.Body.From, request.Body.To
+	swap := request.Body.Swap != nil && *request.Body.Swap
+	if from == to {
+		return api.CreateAdminPromotion400JSONResponse(errorResponse("bad_request", "from and to must be different namespaces")), nil
+	}
+	var rollbackAfter time.Duration
+	if request.Body.RollbackAfter != nil {
+		d, err := time.ParseDuration(*request.Body.RollbackAfter)
+		if err != nil || d <= 0 {
+			return api.CreateAdminPromotion400JSONResponse(errorResponse("bad_request", fmt.Sprintf("invalid rollbackAfter %q, must be a positive duration", *request.Body.RollbackAfter))), nil
+		}
+		rollbackAfter = d
+	}
+
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	cfg := h.config()
+	source, ok := h.namespace(ctx, cfg, from)
+	if !ok {
+		return api.CreateAdminPromotion404JSONResponse{NotFoundJSONResponse: notFound(from)}, nil
+	}
+	destination, ok := h.namespace(ctx, cfg, to)
+	if !ok {
+		return api.CreateAdminPromotion404JSONResponse{NotFoundJSONResponse: notFound(to)}, nil
+	}
+	if destination.Source != NAMESPACE_SOURCE_ADMIN {
+		return api.CreateAdminPromotion409JSONResponse{ConflictJSONResponse: conflict(to, destination.Source)}, nil
+	}
+	if swap && source.Source != NAMESPACE_SOURCE_ADMIN {
+		return api.CreateAdminPromotion409JSONResponse{ConflictJSONResponse: conflict(from, source.Source)}, nil
+	}
+
+	id, err := newPromotionID()
+	if err != nil {
+		return nil, err
+	}
+	p := api.Promotion{
+		Id:       &id,
+		From:     from,
+		To:       to,
+		Swap:     swap,
+		Previous: map[string]string{to: destination.Target},
+		Targets:  map[string]string{to: source.Target},
+	}
+	if swap {
+		p.Previous[from] = source.Target
+		p.Targets[from] = destination.Target
+	}
+	if err := h.setTargets(ctx, p.Targets, AuditRecord{Action: "promote", Promotion: id, From: from}); err != nil {
+		return nil, err
+	}
+	p.Generation = h.status().Generation
+
+	if rollbackAfter > 0 {
+		rollbackAt := time.Now().Add(rollbackAfter).UTC().Truncate(time.Second)
+		p.RollbackAt = &rollbackAt
+		pending := &promotion{Promotion: p}
+		pending.timer = time.AfterFunc(rollbackAfter, func() {
+			h.reloadLock.Lock()
+			defer h.reloadLock.Unlock()
+			if _, ok := h.promotions[id]; !ok {
+				return // confirmed or rolled back meanwhile
+			}
+			if _, err := h.rollbackPromotion(context.Background(), id, "rollback-timer"); err != nil {
+				log.Printf("E: [admin] automatic rollback of promotion %s failed: %v", id, err)
+			}
+		})
+		if h.promotions == nil {
+			h.promotions = map[string]*promotion{}
+		}
+		h.promotions[id] = pending
+	}
+	return api.CreateAdminPromotion200JSONResponse(p), nil
+}
+
+// ConfirmAdminPromotion handles POST /admin/promotions/{id}/confirm
+func (h *AuthzHandler) ConfirmAdminPromotion(ctx context.Context, request api.ConfirmAdminPromotionRequestObject) (api.ConfirmAdminPromotionResponseObject, error) {
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	p, ok := h.promotions[request.Id]
+	if !ok {
+		return api.ConfirmAdminPromotion404JSONResponse{NotFoundJSONResponse: promotionNotFound(request.Id)}, nil
+	}
+	p.timer.Stop()
+	delete(h.promotions, request.Id)
+	log.Printf("I: [admin] promotion %s confirmed by %s", request.Id, auditActor(ctx))
+	return api.ConfirmAdminPromotion204Response{}, nil
+}
+
+// RollbackAdminPromotion handles POST /admin/promotions/{id}/rollback
+func (h *AuthzHandler) RollbackAdminPromotion(ctx context.Context, request api.RollbackAdminPromotionRequestObject) (api.RollbackAdminPromotionResponseObject, error) {
+	h.reloadLock.Lock()
+	defer h.reloadLock.Unlock()
+
+	if _, ok := h.promotions[request.Id]; !ok {
+		return api.RollbackAdminPromotion404JSONResponse{NotFoundJSONResponse: promotionNotFound(request.Id)}, nil
+	}
+	p, err := h.rollbackPromotion(ctx, request.Id, "")
+	if errors.Is(err, errPromotionChanged) {
+		return api.RollbackAdminPromotion409JSONResponse{ConflictJSONResponse: api.ConflictJSONResponse(errorResponse("conflict", err.Error()))}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.RollbackAdminPromotion200JSONResponse(p), nil
+}
+
+// rollbackPromotion restores the previous targets of a pending promotion,
+// unless the namespaces were changed since.  Either way, the rollback is no
+// longer pending.  Callers must hold reloadLock.
+func (h *AuthzHandler) rollbackPromotion(ctx context.Context, id, actor string) (api.Promotion, error) {
+	p, ok := h.promotions[id]
+	if !ok {
+		return api.Promotion{}, fmt.Errorf("promotion %s is not pending", id)
+	}
+	p.timer.Stop()
+	delete(h.promotions, id)
+
+	cfg := h.config()
+	for nsID, target := range p.Targets {
+		ns, ok := h.namespace(ctx, cfg, nsID)
+		if !ok || ns.Source != NAMESPACE_SOURCE_ADMIN || ns.Target != target {
+			return api.Promotion{}, fmt.Errorf("namespace %s %w, not rolling back %s", nsID, errPromotionChanged, id)
+		}
+	}
+
+	if err := h.setTargets(ctx, p.Previous, AuditRecord{Actor: actor, Action: "rollback", Promotion: id, From: p.From}); err != nil {
+		return api.Promotion{}, err
+	}
+	rolledBack := p.Promotion
+	rolledBack.Targets, rolledBack.Previous = maps.Clone(p.Previous), maps.Clone(p.Targets)
+	rolledBack.Generation = h.status().Generation
+	rolledBack.RollbackAt = nil
+	return rolledBack, nil
+}
+
+// setTargets changes the targets of namespaces managed through the admin API
+// as one generation and audits the change of each, based on record.  Callers
+// must hold reloadLock.
+func (h *AuthzHandler) setTargets(ctx context.Context, targets map[string]string, record AuditRecord) error {
+	cfg := h.config()
+	before := map[string]NamespaceConfig{}
+	after := map[string]NamespaceConfig{}
+	for id, target := range targets {
+		ns, _ := h.namespace(ctx, cfg, id)
+		before[id] = ns
+		ns.Target = target
+		after[id] = ns
+	}
+	if err := h.putManagedNamespaces(ctx, after); err != nil {
+		return err
+	}
+	for id := range targets {
+		previous, updated := before[id], after[id]
+		record.Namespace, record.Before, record.After = id, &previous, &updated
+		h.audit(ctx, record)
+	}
+	return nil
+}
+
+func newPromotionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func promotionNotFound(id string) api.NotFoundJSONResponse {
+	return api.NotFoundJSONResponse(errorResponse("not_found", "No pending rollback for promotion "+id))
+}
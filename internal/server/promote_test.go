@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+func TestPromotion(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+`
+
+	// setup creates the admin-managed namespaces production and green
+	setup := func(t *testing.T) (*AuthzHandler, http.Handler, *bytes.Buffer) {
+		t.Helper()
+		var auditLog bytes.Buffer
+		handler := newTestHandler(t, config, WithAuditLog(&auditLog))
+		router := newAdminRouter(t, handler)
+		for id, target := range map[string]string{"production": "blue-v1", "green": "green-v2"} {
+			if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/"+id, "secret", `{"target": "`+target+`"}`); rec.Code != http.StatusCreated {
+				t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+			}
+		}
+		auditLog.Reset()
+		return handler, router, &auditLog
+	}
+
+	promote := func(t *testing.T, router http.Handler, body string) api.Promotion {
+		t.Helper()
+		rec := adminRequest(router, http.MethodPost, "/admin/promotions", "secret", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var p api.Promotion
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("Invalid response %s: %v", rec.Body, err)
+		}
+		return p
+	}
+
+	targets := func(handler *AuthzHandler) string {
+		namespaces := handler.config().Namespaces
+		return namespaces["production"].Target + "," + namespaces["green"].Target
+	}
+
+	t.Run("promote", func(t *testing.T) {
+		handler, router, auditLog := setup(t)
+		generation := handler.status().Generation
+
+		p := promote(t, router, `{"from": "green", "to": "production"}`)
+		if got := targets(handler); got != "green-v2,green-v2" {
+			t.Errorf("Expected production to get green's target, got %s", got)
+		}
+		if p.Generation != generation+1 || handler.status().Generation != generation+1 {
+			t.Errorf("Expected a single new generation %d, got %d", generation+1, p.Generation)
+		}
+		if p.Previous["production"] != "blue-v1" || p.RollbackAt != nil {
+			t.Errorf("Unexpected promotion: %+v", p)
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(auditLog.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", auditLog.String(), err)
+		}
+		if record.Actor != "ci" || record.Action != "promote" || record.Namespace != "production" || record.From != "green" || record.Promotion != *p.Id {
+			t.Errorf("Unexpected audit record: %s", auditLog.String())
+		}
+	})
+
+	t.Run("swap", func(t *testing.T) {
+		handler, router, auditLog := setup(t)
+		generation := handler.status().Generation
+
+		promote(t, router, `{"from": "green", "to": "production", "swap": true}`)
+		if got := targets(handler); got != "green-v2,blue-v1" {
+			t.Errorf("Expected targets to be swapped, got %s", got)
+		}
+		if handler.status().Generation != generation+1 {
+			t.Errorf("Expected a single new generation %d, got %d", generation+1, handler.status().Generation)
+		}
+		if records := strings.Count(auditLog.String(), `"action":"promote"`); records != 2 {
+			t.Errorf("Expected an audit record per namespace, got %d", records)
+		}
+	})
+
+	t.Run("automatic rollback", func(t *testing.T) {
+		handler, router, auditLog := setup(t)
+
+		p := promote(t, router, `{"from": "green", "to": "production", "swap": true, "rollbackAfter": "50ms"}`)
+		if p.RollbackAt == nil {
+			t.Error("Expected rollbackAt to be set")
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for targets(handler) != "blue-v1,green-v2" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := targets(handler); got != "blue-v1,green-v2" {
+			t.Fatalf("Expected targets to be rolled back, got %s", got)
+		}
+		handler.reloadLock.Lock()
+		defer handler.reloadLock.Unlock()
+		if !strings.Contains(auditLog.String(), `"actor":"rollback-timer","action":"rollback"`) {
+			t.Errorf("Expected rollback audit records, got %s", auditLog.String())
+		}
+	})
+
+	t.Run("confirm and manual rollback", func(t *testing.T) {
+		handler, router, _ := setup(t)
+
+		p := promote(t, router, `{"from": "green", "to": "production", "rollbackAfter": "1h"}`)
+		if rec := adminRequest(router, http.MethodPost, "/admin/promotions/"+*p.Id+"/confirm", "secret", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := adminRequest(router, http.MethodPost, "/admin/promotions/"+*p.Id+"/rollback", "secret", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after confirmation, got %d", rec.Code)
+		}
+
+		p = promote(t, router, `{"from": "production", "to": "green", "rollbackAfter": "1h"}`)
+		if rec := adminRequest(router, http.MethodPost, "/admin/promotions/"+*p.Id+"/rollback", "secret", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if got := targets(handler); got != "green-v2,green-v2" {
+			t.Errorf("Expected green to be rolled back, got %s", got)
+		}
+	})
+
+	t.Run("rollback after a change", func(t *testing.T) {
+		handler, router, _ := setup(t)
+
+		p := promote(t, router, `{"from": "green", "to": "production", "rollbackAfter": "1h"}`)
+		if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/production", "secret", `{"target": "hotfix"}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := adminRequest(router, http.MethodPost, "/admin/promotions/"+*p.Id+"/rollback", "secret", ""); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rec.Code, rec.Body)
+		}
+		if target := handler.config().Namespaces["production"].Target; target != "hotfix" {
+			t.Errorf("Expected the later change to be kept, got %s", target)
+		}
+	})
+
+	t.Run("rejects invalid promotions", func(t *testing.T) {
+		handler, router, _ := setup(t)
+		generation := handler.status().Generation
+
+		type testCase struct {
+			body     string
+			expected int
+		}
+		tests := []testCase{
+			{`{"from": "green", "to": "green"}`, http.StatusBadRequest},
+			{`{"from": "green", "to": "production", "rollbackAfter": "soon"}`, http.StatusBadRequest},
+			{`{"from": "missing", "to": "production"}`, http.StatusNotFound},
+			{`{"from": "green", "to": "cool-otter"}`, http.StatusConflict},
+			{`{"from": "cool-otter", "to": "production", "swap": true}`, http.StatusConflict},
+		}
+		for _, tc := range tests {
+			if rec := adminRequest(router, http.MethodPost, "/admin/promotions", "secret", tc.body); rec.Code != tc.expected {
+				t.Errorf("%s: expected %d, got %d: %s", tc.body, tc.expected, rec.Code, rec.Body)
+			}
+		}
+		if handler.status().Generation != generation {
+			t.Errorf("Expected no new generation, got %d", handler.status().Generation)
+		}
+
+		// promoting a static namespace's target is fine
+		promote(t, router, `{"from": "cool-otter", "to": "production"}`)
+		if target := handler.config().Namespaces["production"].Target; target != "blue" {
+			t.Errorf("Expected production to get target blue, got %s", target)
+		}
+	})
+}
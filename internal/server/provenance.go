@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const CONFIG_GENERATION_HEADER = "x-authz-config-generation"
+
+// redactedKeyPattern matches configuration keys whose values are secret.
+var redactedKeyPattern = regexp.MustCompile(`(?i)(secret|token|password|key)s?$`)
+
+// ConfigStatus identifies the configuration in effect.
+type ConfigStatus struct {
+	// Checksum is the SHA-256 of the configuration file, or for a
+	// directory, of the sha256sum(1) listing of its fragments.
+	Checksum string `json:"checksum"`
+	// Generation is incremented on every successful load.
+	Generation int64     `json:"generation"`
+	LoadedAt   time.Time `json:"loadedAt"`
+}
+
+// configChecksum returns the checksum of the configuration documents.  For
+// a single file it matches `sha256sum config.yaml`, for a directory
+// `sha256sum *.yaml | sha256sum`.
+func configChecksum(docs []configDocument) string {
+	if len(docs) == 1 {
+		sum := sha256.Sum256(docs[0].Data)
+		return hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(fragmentManifest(docs))
+	return hex.EncodeToString(sum[:])
+}
+
+// status returns the status of the current configuration.
+func (h *AuthzHandler) status() ConfigStatus {
+	h.configLock.RLock()
+	defer h.configLock.RUnlock()
+	return h.currentConfig.status
+}
+
+// GetDebugConfigResponse represents the effective configuration dump
+type GetDebugConfigResponse struct {
+	Status ConfigStatus `json:"status"`
+	Config any          `json:"config"`
+}
+
+// GetDebugConfigHandler serves the effective configuration with secrets
+// redacted, along with its checksum and generation.
+func (h *AuthzHandler) GetDebugConfigHandler(c *gin.Context) {
+	cfg := h.config()
+	dump, err := redactConfig(cfg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GetDebugConfigResponse{
+		Status: cfg.status,
+		Config: dump,
+	})
+}
+
+// redactConfig converts the configuration to generic JSON values, replacing
+// values read from ${file:...} references and values of keys that look like
+// secrets.
+func redactConfig(cfg AuthzConfig) (any, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var dump any
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, err
+	}
+
+	var redact func(value any, secretKey bool) any
+	redact = func(value any, secretKey bool) any {
+		switch value := value.(type) {
+		case map[string]any:
+			for k, v := range value {
+				value[k] = redact(v, redactedKeyPattern.MatchString(k))
+			}
+		case []any:
+			for i, v := range value {
+				value[i] = redact(v, secretKey)
+			}
+		case string:
+			if value != "" && (secretKey || slices.Contains(cfg.secrets, value)) {
+				return "<redacted>"
+			}
+		}
+		return value
+	}
+	return redact(dump, false), nil
+}
+
+// RegisterMetrics registers gauges describing the configuration in effect.
+func (h *AuthzHandler) RegisterMetrics(meter metric.Meter) error {
+	generation, err := meter.Int64ObservableGauge(
+		"authz_config_generation",
+		metric.WithDescription("Generation of the configuration in effect, incremented on every load"),
+	)
+	if err != nil {
+		return err
+	}
+	loadedAt, err := meter.Float64ObservableGauge(
+		"authz_config_loaded_timestamp_seconds",
+		metric.WithDescription("Time the configuration in effect was loaded, in seconds since the epoch"),
+	)
+	if err != nil {
+		return err
+	}
+	info, err := meter.Int64ObservableGauge(
+		"authz_config_info",
+		metric.WithDescription("Checksum of the configuration in effect"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		status := h.status()
+		if status.Generation == 0 {
+			return nil // nothing loaded yet
+		}
+		o.ObserveInt64(generation, status.Generation)
+		o.ObserveFloat64(loadedAt, float64(status.LoadedAt.UnixNano())/1e9)
+		o.ObserveInt64(info, 1, metric.WithAttributes(attribute.String("checksum", status.Checksum)))
+		return nil
+	}, generation, loadedAt, info)
+	return err
+}
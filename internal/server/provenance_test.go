@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigProvenance(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`
+
+	t.Run("checksum and generation", func(t *testing.T) {
+		handler := newTestHandler(t, config)
+		sum := sha256.Sum256([]byte(config))
+
+		status := handler.status()
+		if status.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("Expected checksum of the config file, got %s", status.Checksum)
+		}
+		if status.Generation != 1 || status.LoadedAt.IsZero() {
+			t.Errorf("Expected first generation with load time, got %+v", status)
+		}
+
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Failed to reload config: %v", err)
+		}
+		if status := handler.status(); status.Generation != 2 {
+			t.Errorf("Expected generation 2 after reload, got %d", status.Generation)
+		}
+
+		if err := os.WriteFile(handler.configPath, []byte("invalid yaml content: [[["), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		if err := handler.loadConfig(); err == nil {
+			t.Fatalf("Expected invalid config to fail")
+		}
+		if status := handler.status(); status.Generation != 2 {
+			t.Errorf("Expected failed load to keep generation 2, got %d", status.Generation)
+		}
+	})
+
+	t.Run("generation header", func(t *testing.T) {
+		server := NewAuthzGRPCServer(newTestHandler(t, "server:\n  exposeConfigGeneration: true\n"+config))
+		for _, headers := range []map[string]string{
+			{"x-namespace": "cool-otter"},
+			{"x-namespace": "awesome-penguin"},
+		} {
+			resp, err := server.Check(context.Background(), newCheckRequest("envdemo.test", "/", headers))
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			var generation string
+			for _, option := range resp.GetOkResponse().GetResponseHeadersToAdd() {
+				generation = option.GetHeader().GetValue()
+			}
+			if denied := responseHeaders(resp)[CONFIG_GENERATION_HEADER]; denied != "" {
+				generation = denied
+			}
+			if generation != "1" {
+				t.Errorf("Expected generation header 1 for %v, got %q", headers, generation)
+			}
+		}
+	})
+
+	t.Run("redacted dump", func(t *testing.T) {
+		tokenPath := filepath.Join(t.TempDir(), "target")
+		if err := os.WriteFile(tokenPath, []byte("s3cr3t\n"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		handler := newTestHandler(t, config+`
+  awesome-penguin:
+    target: red
+    description: "${file:`+tokenPath+`}"
+`)
+
+		router := newAdminRouter(t, handler)
+		handler.RegisterRoutes(router)
+		for _, token := range []string{"", "wrong"} {
+			if w := adminRequest(router, http.MethodGet, "/debug/config", token, ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 with token %q, got %d", token, w.Code)
+			}
+		}
+		w := adminRequest(router, http.MethodGet, "/debug/config", "secret", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		var dump struct {
+			Status ConfigStatus
+			Config AuthzConfig
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &dump); err != nil {
+			t.Fatalf("Failed to decode dump: %v", err)
+		}
+		if dump.Status.Generation != 1 {
+			t.Errorf("Expected generation 1, got %d", dump.Status.Generation)
+		}
+		if ns := dump.Config.Namespaces["awesome-penguin"]; ns.Description != "<redacted>" {
+			t.Errorf("Expected value from file to be redacted, got %q", ns.Description)
+		}
+		if ns := dump.Config.Namespaces["cool-otter"]; ns.Target != "blue" || ns.Source != "config.yaml" {
+			t.Errorf("Expected cool-otter in dump, got %+v", ns)
+		}
+		if !strings.Contains(w.Body.String(), `"cookieExpiration":"24h0m0s"`) {
+			t.Errorf("Expected cookieExpiration as a duration, got %s", w.Body.String())
+		}
+		if expiration := dump.Config.Server.CookieExpiration; expiration != COOKIE_EXPIRATION {
+			t.Errorf("Expected cookieExpiration %v, got %v", COOKIE_EXPIRATION, expiration)
+		}
+	})
+}
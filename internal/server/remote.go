@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	CONFIG_POLL_INTERVAL    = 30 * time.Second
+	CONFIG_POLL_MAX_BACKOFF = 5 * time.Minute
+	CONFIG_FETCH_TIMEOUT    = 10 * time.Second
+
+	// CONFIG_MAX_SIZE limits the size of a remote configuration document.
+	CONFIG_MAX_SIZE = 16 << 20
+)
+
+// errConfigNotModified is returned by remoteConfig.fetch when the remote
+// configuration has not changed since the last fetch.
+var errConfigNotModified = errors.New("configuration not modified")
+
+// WithConfigURL reads the configuration from an HTTP(S) URL, which is polled
+// for changes, instead of the configuration path.
+func WithConfigURL(url string) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.configURL = url
+	}
+}
+
+// WithConfigPollInterval sets how often the configuration URL is polled.
+func WithConfigPollInterval(d time.Duration) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.pollInterval = d
+	}
+}
+
+// remoteConfig fetches a configuration document over HTTP, using the ETag
+// of the last loaded response to make conditional requests.
+type remoteConfig struct {
+	url    string
+	client *http.Client
+	etag   string
+	// signed fetches the detached signature along with the document
+	signed bool
+}
+
+func newRemoteConfig(url string) *remoteConfig {
+	return &remoteConfig{
+		url:    url,
+		client: &http.Client{Timeout: CONFIG_FETCH_TIMEOUT},
+	}
+}
+
+// fetch returns the configuration document and its ETag, or
+// errConfigNotModified if the server reports that it is unchanged since the
+// last loaded one.
+func (r *remoteConfig) fetch(ctx context.Context) ([]configDocument, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/yaml, text/yaml, */*")
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, "", errConfigNotModified
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("%s: unexpected status %s", r.url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, CONFIG_MAX_SIZE+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", r.url, err)
+	}
+	if len(data) > CONFIG_MAX_SIZE {
+		return nil, "", fmt.Errorf("%s: configuration exceeds %d bytes", r.url, CONFIG_MAX_SIZE)
+	}
+	doc := configDocument{Name: r.url, Data: data}
+	if r.signed {
+		if doc.Signature, err = r.fetchSignature(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+	return []configDocument{doc}, resp.Header.Get("ETag"), nil
+}
+
+// fetchSignature returns the detached signature of the document, or nil if
+// there is none.
+func (r *remoteConfig) fetchSignature(ctx context.Context) ([]byte, error) {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += CONFIG_SIGNATURE_SUFFIX
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: unexpected status %s", u, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, CONFIG_MAX_SIZE))
+}
+
+// loadRemoteConfig fetches and loads the configuration from the
+// configuration URL.  An unchanged configuration is not reloaded.  The ETag
+// is only remembered once a configuration has loaded, so that one that
+// failed to load is fetched again on the next poll.
+func (h *AuthzHandler) loadRemoteConfig() error {
+	docs, etag, err := h.remote.fetch(context.Background())
+	if errors.Is(err, errConfigNotModified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.loadDocuments(docs, nil); err != nil {
+		return err
+	}
+	h.remote.etag = etag
+	return nil
+}
+
+// pollConfig reloads the configuration from the configuration URL every
+// poll interval, or on SIGHUP, until ctx is cancelled.  After a failed
+// reload the interval is doubled on each attempt, up to
+// CONFIG_POLL_MAX_BACKOFF, and the last good configuration stays in effect.
+func (h *AuthzHandler) pollConfig(ctx context.Context) error {
+	log.Printf("I: polling %v every %v for configuration changes", h.configURL, h.pollInterval)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	failures := 0
+	timer := time.NewTimer(pollDelay(h.pollInterval, failures))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			log.Println("I: [config] SIGHUP received, reloading")
+			timer.Stop()
+		case <-timer.C:
+		}
+		if err := h.reloadConfig(); err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		timer.Reset(pollDelay(h.pollInterval, failures))
+	}
+}
+
+// pollDelay returns the time to wait before the next poll after the given
+// number of consecutive failures.
+func pollDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for range failures {
+		if delay >= CONFIG_POLL_MAX_BACKOFF/2 {
+			return max(CONFIG_POLL_MAX_BACKOFF, interval)
+		}
+		delay *= 2
+	}
+	return delay
+}
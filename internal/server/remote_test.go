@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// configServer serves a configuration document with an ETag, or fails with
+// status if it is set.
+type configServer struct {
+	lock            sync.Mutex
+	config          string
+	status          int
+	notModified     int
+	lastIfNoneMatch string
+}
+
+func (s *configServer) set(config string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config, s.status = config, status
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastIfNoneMatch = r.Header.Get("If-None-Match")
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(s.config)))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write([]byte(s.config))
+}
+
+func TestRemoteConfig(t *testing.T) {
+	t.Run("conditional reload", func(t *testing.T) {
+		remote := &configServer{config: "namespaces:\n  cool-otter:\n    target: blue\n"}
+		ts := httptest.NewServer(remote)
+		defer ts.Close()
+
+		handler := NewServerHandler("http://test", nil, WithConfigURL(ts.URL))
+		if handler.config().Namespaces["cool-otter"].Target != "blue" {
+			t.Fatalf("Expected initial config to be loaded, got %v", handler.config().Namespaces)
+		}
+		if source := handler.config().Namespaces["cool-otter"].Source; source != ts.URL {
+			t.Errorf("Expected source %s, got %s", ts.URL, source)
+		}
+
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if remote.notModified != 1 || remote.lastIfNoneMatch == "" {
+			t.Errorf("Expected a conditional request answered with 304, got %d (If-None-Match %q)", remote.notModified, remote.lastIfNoneMatch)
+		}
+		if generation := handler.status().Generation; generation != 1 {
+			t.Errorf("Expected unchanged config to keep generation 1, got %d", generation)
+		}
+
+		remote.set("namespaces:\n  cool-otter:\n    target: red\n", 0)
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "red" {
+			t.Errorf("Expected target red, got %s", target)
+		}
+		if generation := handler.status().Generation; generation != 2 {
+			t.Errorf("Expected generation 2, got %d", generation)
+		}
+	})
+
+	t.Run("failures keep last good config", func(t *testing.T) {
+		remote := &configServer{config: "namespaces:\n  cool-otter:\n    target: blue\n"}
+		ts := httptest.NewServer(remote)
+		defer ts.Close()
+
+		handler := NewServerHandler("http://test", nil, WithConfigURL(ts.URL))
+
+		remote.set("", http.StatusInternalServerError)
+		if err := handler.loadConfig(); err == nil {
+			t.Error("Expected error for status 500, got nil")
+		}
+		remote.set("invalid yaml content: [[[", 0)
+		if err := handler.loadConfig(); err == nil {
+			t.Error("Expected error for invalid config, got nil")
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Errorf("Expected last good target blue, got %s", target)
+		}
+
+		// an invalid document is not remembered, so fixing it is picked up
+		remote.set("namespaces:\n  cool-otter:\n    target: blue\n", 0)
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if remote.notModified != 1 {
+			t.Errorf("Expected 304 for the last good config, got %d", remote.notModified)
+		}
+	})
+
+	t.Run("unreachable at startup", func(t *testing.T) {
+		remote := &configServer{}
+		remote.set("", http.StatusServiceUnavailable)
+		ts := httptest.NewServer(remote)
+		defer ts.Close()
+
+		handler := NewServerHandler("http://test", nil, WithConfigURL(ts.URL), WithConfigPollInterval(10*time.Millisecond))
+		if handler.status().Generation != 0 {
+			t.Fatal("Expected no config to be loaded")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.WatchConfig(ctx)
+
+		remote.set("namespaces:\n  cool-otter:\n    target: blue\n", 0)
+		waitForTarget(t, handler, "cool-otter", "blue")
+	})
+}
+
+func TestPollDelay(t *testing.T) {
+	type testCase struct {
+		interval time.Duration
+		failures int
+		expected time.Duration
+	}
+	tests := []testCase{
+		{30 * time.Second, 0, 30 * time.Second},
+		{30 * time.Second, 1, time.Minute},
+		{30 * time.Second, 3, 4 * time.Minute},
+		{30 * time.Second, 4, CONFIG_POLL_MAX_BACKOFF},
+		{30 * time.Second, 100, CONFIG_POLL_MAX_BACKOFF},
+		{10 * time.Minute, 2, 10 * time.Minute},
+	}
+	for _, tc := range tests {
+		if delay := pollDelay(tc.interval, tc.failures); delay != tc.expected {
+			t.Errorf("pollDelay(%v, %d): expected %v, got %v", tc.interval, tc.failures, tc.expected, delay)
+		}
+	}
+}
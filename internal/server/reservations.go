@@ -0,0 +1,214 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+const (
+	// CLIENT_COOKIE_SUFFIX is appended to the namespace cookie name to name
+	// the cookie holding the client identity.
+	CLIENT_COOKIE_SUFFIX = "-client"
+	// CLIENT_COOKIE_EXPIRATION is how long a client identity is kept.
+	CLIENT_COOKIE_EXPIRATION = 365 * 24 * time.Hour
+
+	RESERVATION_DURATION     = time.Hour
+	RESERVATION_MAX_DURATION = 7 * 24 * time.Hour
+	RESERVATION_MAX_HOLDER   = 64
+
+	EVENT_RESERVATION_EXPIRED = "reservation.expired"
+)
+
+// catalogNamespace identifies a namespace in the catalog it belongs to, see
+// forHost, as catalogs may define namespaces of the same ID.  Namespaces
+// outside catalogs have an empty catalog.
+type catalogNamespace struct {
+	catalog, id string
+}
+
+// String returns the ID of the namespace, qualified with its catalog.
+func (n catalogNamespace) String() string {
+	if n.catalog == "" {
+		return n.id
+	}
+	return n.id + " in catalog " + n.catalog
+}
+
+// compareCatalogNamespaces orders namespaces by catalog and ID.
+func compareCatalogNamespaces(a, b catalogNamespace) int {
+	return cmp.Or(strings.Compare(a.catalog, b.catalog), strings.Compare(a.id, b.id))
+}
+
+// reservation is a namespace held by a client for a time window.
+type reservation struct {
+	Holder    string
+	ClientID  string
+	Until     time.Time
+	Exclusive bool
+}
+
+// clientID returns the identity of the requesting client from its cookie.
+// If issue is set and the client has none yet, a new identity is issued.
+func (h *AuthzHandler) clientID(ctx context.Context, cfg AuthzConfig, issue bool) string {
+	c, ok := ctx.(*gin.Context)
+	if !ok || c.Request == nil {
+		return ""
+	}
+	name := cfg.Server.CookieName + CLIENT_COOKIE_SUFFIX
+	if id, err := c.Cookie(name); err == nil && id != "" {
+		return id
+	}
+	if !issue {
+		return ""
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("E: failed to issue client identity: %v", err)
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    id,
+		Path:     "/",
+		Domain:   cfg.Server.CookieDomain,
+		Expires:  time.Now().Add(CLIENT_COOKIE_EXPIRATION),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// reservation returns the current reservation of a namespace in a catalog.
+func (h *AuthzHandler) reservation(catalog, id string, now time.Time) (reservation, bool) {
+	h.reservationLock.Lock()
+	defer h.reservationLock.Unlock()
+	r, ok := h.reservations[catalogNamespace{catalog, id}]
+	if !ok || !now.Before(r.Until) {
+		return reservation{}, false
+	}
+	return r, true
+}
+
+// apiReservation describes a reservation to the client with identity client.
+func apiReservation(r reservation, client string) api.Reservation {
+	return api.Reservation{
+		Holder:    r.Holder,
+		Until:     r.Until,
+		Exclusive: r.Exclusive,
+		Yours:     client != "" && r.ClientID == client,
+	}
+}
+
+// PutReservation handles PUT /namespaces/{id}/reservation - Reserve a
+// namespace of the catalog for redirect_to for the requesting client
+func (h *AuthzHandler) PutReservation(ctx context.Context, request api.PutReservationRequestObject) (api.PutReservationResponseObject, error) {
+	holder := strings.TrimSpace(request.Body.Holder)
+	if holder == "" || len(holder) > RESERVATION_MAX_HOLDER {
+		return api.PutReservation400JSONResponse(errorResponse("bad_request", fmt.Sprintf("holder must be 1 to %d characters", RESERVATION_MAX_HOLDER))), nil
+	}
+	duration := RESERVATION_DURATION
+	if request.Body.Duration != nil {
+		d, err := time.ParseDuration(*request.Body.Duration)
+		if err != nil || d <= 0 || d > RESERVATION_MAX_DURATION {
+			return api.PutReservation400JSONResponse(errorResponse("bad_request", fmt.Sprintf("invalid duration %q, must be positive and at most %v", *request.Body.Duration, RESERVATION_MAX_DURATION))), nil
+		}
+		duration = d
+	}
+	exclusive := request.Body.Exclusive == nil || *request.Body.Exclusive
+
+	cfg := h.config().forRedirect(request.Params.RedirectTo, requestHost(ctx))
+	now := time.Now()
+	ns, ok := h.namespaceOrPattern(ctx, cfg, request.Id)
+	if !ok || ns.expired(now) || !ns.selectable() {
+		return api.PutReservation404JSONResponse{NotFoundJSONResponse: notFound(request.Id)}, nil
+	}
+	client := h.clientID(ctx, cfg, true)
+	if client == "" {
+		return api.PutReservation400JSONResponse(errorResponse("bad_request", "no client identity")), nil
+	}
+
+	key := catalogNamespace{cfg.catalog, request.Id}
+	h.reservationLock.Lock()
+	defer h.reservationLock.Unlock()
+	if r, ok := h.reservations[key]; ok && now.Before(r.Until) && r.ClientID != client {
+		return api.PutReservation409JSONResponse(reservedError(request.Id, r)), nil
+	}
+	r := reservation{
+		Holder:    holder,
+		ClientID:  client,
+		Until:     now.Add(duration).UTC().Truncate(time.Second),
+		Exclusive: exclusive,
+	}
+	if h.reservations == nil {
+		h.reservations = map[catalogNamespace]reservation{}
+	}
+	h.reservations[key] = r
+	log.Printf("I: namespace %s reserved by %s until %s", key, holder, r.Until.Format(time.RFC3339))
+	return api.PutReservation200JSONResponse(apiReservation(r, client)), nil
+}
+
+// DeleteReservation handles DELETE /namespaces/{id}/reservation - Release
+// the reservation of the requesting client
+func (h *AuthzHandler) DeleteReservation(ctx context.Context, request api.DeleteReservationRequestObject) (api.DeleteReservationResponseObject, error) {
+	cfg := h.config().forRedirect(request.Params.RedirectTo, requestHost(ctx))
+	client := h.clientID(ctx, cfg, false)
+
+	key := catalogNamespace{cfg.catalog, request.Id}
+	h.reservationLock.Lock()
+	defer h.reservationLock.Unlock()
+	r, ok := h.reservations[key]
+	if !ok || !time.Now().Before(r.Until) {
+		return api.DeleteReservation404JSONResponse{NotFoundJSONResponse: api.NotFoundJSONResponse(errorResponse("not_found", "Namespace "+request.Id+" is not reserved"))}, nil
+	}
+	if client == "" || r.ClientID != client {
+		return api.DeleteReservation409JSONResponse(reservedError(request.Id, r)), nil
+	}
+	delete(h.reservations, key)
+	log.Printf("I: namespace %s released by %s", key, r.Holder)
+	return api.DeleteReservation204Response{}, nil
+}
+
+// purgeReservations deletes the reservations that have expired at now, and
+// returns the IDs of their namespaces.
+func (h *AuthzHandler) purgeReservations(now time.Time) []string {
+	h.reservationLock.Lock()
+	var expired []catalogNamespace
+	var released []reservation
+	for _, key := range slices.SortedFunc(maps.Keys(h.reservations), compareCatalogNamespaces) {
+		if r := h.reservations[key]; !now.Before(r.Until) {
+			expired = append(expired, key)
+			released = append(released, r)
+			delete(h.reservations, key)
+		}
+	}
+	h.reservationLock.Unlock()
+
+	var ids []string
+	for i, key := range expired {
+		ids = append(ids, key.id)
+		h.emit(Event{
+			Type:      EVENT_RESERVATION_EXPIRED,
+			Namespace: key.id,
+			Message:   fmt.Sprintf("reservation of namespace %s by %s expired at %s", key, released[i].Holder, released[i].Until.Format(time.RFC3339)),
+		})
+	}
+	return ids
+}
+
+func reservedError(id string, r reservation) api.ErrorResponse {
+	return errorResponse("reserved", fmt.Sprintf("Namespace %s is reserved by %s until %s", id, r.Holder, r.Until.Format(time.RFC3339)))
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/michaelw/ext-authz-router/api"
+)
+
+// client sends requests with the cookies it was given, like a browser.
+type client struct {
+	router  http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (c *client) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if c.cookies == nil {
+			c.cookies = map[string]*http.Cookie{}
+		}
+		c.cookies[cookie.Name] = cookie
+	}
+	return rec
+}
+
+func TestReservations(t *testing.T) {
+	const config = `
+namespaces:
+  qa-1:
+    target: qa-1
+  qa-2:
+    target: qa-2
+    state: draining
+`
+
+	setup := func(t *testing.T) (*AuthzHandler, *client, *client) {
+		t.Helper()
+		handler := newTestHandler(t, config)
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		api.RegisterHandlers(router, api.NewStrictHandler(handler, nil))
+		return handler, &client{router: router}, &client{router: router}
+	}
+
+	namespaces := func(t *testing.T, c *client) map[string]api.NamespaceAttributes {
+		t.Helper()
+		rec := c.do(http.MethodGet, "/namespaces", "")
+		var list api.NamespaceList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("Invalid response %s: %v", rec.Body, err)
+		}
+		return list.Namespaces
+	}
+
+	t.Run("exclusive", func(t *testing.T) {
+		_, alice, bob := setup(t)
+
+		rec := alice.do(http.MethodPut, "/namespaces/qa-1/reservation", `{"holder": "alice", "duration": "2h"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if alice.cookies["namespace"+CLIENT_COOKIE_SUFFIX] == nil {
+			t.Fatal("Expected a client identity to be issued")
+		}
+
+		if r := namespaces(t, bob)["qa-1"].Reservation; r == nil || r.Holder != "alice" || r.Yours || time.Until(r.Until) < time.Hour {
+			t.Errorf("Expected bob to see alice's reservation, got %+v", r)
+		}
+		if r := namespaces(t, alice)["qa-1"].Reservation; r == nil || !r.Yours {
+			t.Errorf("Expected alice to see her own reservation, got %+v", r)
+		}
+
+		if rec := bob.do(http.MethodPost, "/submit", `{"value": "qa-1"}`); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for bob, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := alice.do(http.MethodPost, "/submit", `{"value": "qa-1"}`); rec.Code != http.StatusFound {
+			t.Errorf("Expected 302 for alice, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := bob.do(http.MethodPut, "/namespaces/qa-1/reservation", `{"holder": "bob"}`); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for bob's reservation, got %d", rec.Code)
+		}
+		if rec := bob.do(http.MethodDelete, "/namespaces/qa-1/reservation", ""); rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for bob's release, got %d", rec.Code)
+		}
+
+		if rec := alice.do(http.MethodDelete, "/namespaces/qa-1/reservation", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec := bob.do(http.MethodPost, "/submit", `{"value": "qa-1"}`); rec.Code != http.StatusFound {
+			t.Errorf("Expected 302 after release, got %d: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		_, alice, bob := setup(t)
+
+		if rec := alice.do(http.MethodPut, "/namespaces/qa-1/reservation", `{"holder": "alice", "exclusive": false}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		rec := bob.do(http.MethodPost, "/submit", `{"value": "qa-1"}`)
+		if rec.Code != http.StatusFound {
+			t.Errorf("Expected 302, got %d: %s", rec.Code, rec.Body)
+		}
+		if warning := rec.Header().Get("Warning"); !strings.Contains(warning, "reserved by alice") {
+			t.Errorf("Expected a warning, got %q", warning)
+		}
+		// the namespace cookie is still set
+		if !strings.HasPrefix(rec.Header().Get("Set-Cookie"), "namespace=qa-1;") {
+			t.Errorf("Expected namespace cookie, got %q", rec.Header().Get("Set-Cookie"))
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		handler, alice, bob := setup(t)
+		var events []Event
+		WithEventListener(func(e Event) { events = append(events, e) })(handler)
+
+		if rec := alice.do(http.MethodPut, "/namespaces/qa-1/reservation", `{"holder": "alice"}`); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if _, ok := handler.reservation("", "qa-1", time.Now().Add(2*time.Hour)); ok {
+			t.Error("Expected reservation to lapse after its duration")
+		}
+		if purged := handler.purgeReservations(time.Now().Add(2 * time.Hour)); strings.Join(purged, ",") != "qa-1" {
+			t.Errorf("Expected qa-1 reservation to be purged, got %v", purged)
+		}
+		if len(events) != 1 || events[0].Type != EVENT_RESERVATION_EXPIRED {
+			t.Errorf("Expected a reservation.expired event, got %+v", events)
+		}
+		if rec := bob.do(http.MethodPost, "/submit", `{"value": "qa-1"}`); rec.Code != http.StatusFound {
+			t.Errorf("Expected 302, got %d: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("rejects invalid reservations", func(t *testing.T) {
+		_, alice, _ := setup(t)
+
+		type testCase struct {
+			path, body string
+			expected   int
+		}
+		tests := []testCase{
+			{"/namespaces/qa-1/reservation", `{"holder": " "}`, http.StatusBadRequest},
+			{"/namespaces/qa-1/reservation", `{"holder": "alice", "duration": "30d"}`, http.StatusBadRequest},
+			{"/namespaces/qa-1/reservation", `{"holder": "alice", "duration": "720h"}`, http.StatusBadRequest},
+			{"/namespaces/qa-2/reservation", `{"holder": "alice"}`, http.StatusNotFound},
+			{"/namespaces/missing/reservation", `{"holder": "alice"}`, http.StatusNotFound},
+		}
+		for _, tc := range tests {
+			if rec := alice.do(http.MethodPut, tc.path, tc.body); rec.Code != tc.expected {
+				t.Errorf("%s %s: expected %d, got %d: %s", tc.path, tc.body, tc.expected, rec.Code, rec.Body)
+			}
+		}
+		if rec := alice.do(http.MethodDelete, "/namespaces/qa-1/reservation", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unreserved namespace, got %d", rec.Code)
+		}
+	})
+
+	t.Run("without client identity", func(t *testing.T) {
+		handler, _, _ := setup(t)
+		if id := handler.clientID(context.Background(), handler.config(), true); id != "" {
+			t.Errorf("Expected no identity outside HTTP requests, got %q", id)
+		}
+	})
+}
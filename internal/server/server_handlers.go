@@ -19,6 +19,22 @@ func (h *AuthzHandler) GetHealthzHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// GetReadyzHandler handles the readiness check endpoint.  The service is not
+// ready until a configuration has been loaded successfully.
+func (h *AuthzHandler) GetReadyzHandler(c *gin.Context) {
+	h.configLock.RLock()
+	loaded := h.configLoaded
+	h.configLock.RUnlock()
+
+	if !loaded {
+		c.JSON(http.StatusServiceUnavailable, GetHealthzResponse{
+			Status: "DOWN",
+		})
+		return
+	}
+	h.GetHealthzHandler(c)
+}
+
 // GetOpenAPIJSONHandler serves the OpenAPI specification as JSON
 func (h *AuthzHandler) GetOpenAPIJSONHandler(c *gin.Context) {
 	if h.Swagger == nil {
@@ -31,9 +47,13 @@ func (h *AuthzHandler) GetOpenAPIJSONHandler(c *gin.Context) {
 
 // RegisterRoutes registers internal server routes
 func (h *AuthzHandler) RegisterRoutes(router gin.IRouter) {
-	router.GET("/ready", h.GetHealthzHandler)    // ready to serve requests
-	router.GET("/readyz", h.GetHealthzHandler)   // alias
+	router.GET("/ready", h.GetReadyzHandler)     // ready to serve requests
+	router.GET("/readyz", h.GetReadyzHandler)    // alias
 	router.GET("/health", h.GetHealthzHandler)   // live, but may not be ready
 	router.GET("/healthz", h.GetHealthzHandler)  // alias
 	router.GET("/startupz", h.GetHealthzHandler) // startup check
+
+	router.GET("/debug/config", h.RequireAdmin, h.GetDebugConfigHandler) // effective configuration, for admins
+
+	router.POST("/webhooks/github", h.PostGitHubWebhookHandler) // pull request previews
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// withDefaults returns the settings with environment variable overrides
+// applied and unset values filled in from the defaults.
+func (s ServerConfig) withDefaults() (ServerConfig, error) {
+	overrideString(&s.CookieName, "COOKIE_NAME", COOKIE_NAME)
+	overrideString(&s.CookieDomain, "COOKIE_DOMAIN", COOKIE_DOMAIN)
+	overrideString(&s.RedirectURL, "REDIRECT_URL", REDIRECT_URL)
+	overrideString(&s.BackendHeader, "BACKEND_HEADER", BACKEND_HEADER)
+
+	if value := os.Getenv("EXPOSE_CONFIG_GENERATION"); value != "" {
+		expose, err := strconv.ParseBool(value)
+		if err != nil {
+			return s, fmt.Errorf("EXPOSE_CONFIG_GENERATION: %w", err)
+		}
+		s.ExposeConfigGeneration = expose
+	}
+	if value := os.Getenv("COOKIE_EXPIRATION"); value != "" {
+		expiration, err := time.ParseDuration(value)
+		if err != nil {
+			return s, fmt.Errorf("COOKIE_EXPIRATION: %w", err)
+		}
+		s.CookieExpiration = expiration
+	}
+	if s.CookieExpiration == 0 {
+		s.CookieExpiration = COOKIE_EXPIRATION
+	}
+	if value := os.Getenv("EXTRACTORS"); value != "" {
+		s.Extractors = parseExtractors(value)
+	}
+	if len(s.Extractors) == 0 {
+		s.Extractors = parseExtractors(EXTRACTORS)
+	}
+	s.Extractors = withExtractorDefaults(s.Extractors, s.CookieName)
+	return s, nil
+}
+
+// overrideString sets *value from the environment variable env if it is
+// set, or to fallback if *value is empty.
+func overrideString(value *string, env, fallback string) {
+	if override := os.Getenv(env); override != "" {
+		*value = override
+	}
+	if *value == "" {
+		*value = fallback
+	}
+}
+
+// validate checks the settings that are set.
+func (s ServerConfig) validate() []error {
+	var errs []error
+	if s.CookieName != "" && !isToken(s.CookieName) {
+		errs = append(errs, fmt.Errorf("server.cookieName: %q is not a valid cookie name", s.CookieName))
+	}
+	if strings.ContainsAny(s.CookieDomain, "; \t") {
+		errs = append(errs, fmt.Errorf("server.cookieDomain: %q is not a valid domain", s.CookieDomain))
+	}
+	if s.CookieExpiration < 0 {
+		errs = append(errs, errors.New("server.cookieExpiration: must not be negative"))
+	}
+	if s.RedirectURL != "" {
+		if u, err := url.Parse(s.RedirectURL); err != nil || !u.IsAbs() {
+			errs = append(errs, fmt.Errorf("server.redirectURL: %q is not an absolute URL", s.RedirectURL))
+		}
+	}
+	if s.BackendHeader != "" && !isToken(s.BackendHeader) {
+		errs = append(errs, fmt.Errorf("server.backendHeader: %q is not a valid header name", s.BackendHeader))
+	}
+	errs = append(errs, validateExtractors(s.Extractors)...)
+	return errs
+}
+
+// isToken reports whether s is a valid HTTP token (RFC 9110, section 5.6.2),
+// as used for header and cookie names.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > 0x7E || r <= 0x20 || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// serverConfigJSON is ServerConfig with CookieExpiration as a duration
+// string, as in the YAML configuration and COOKIE_EXPIRATION.
+type serverConfigJSON struct {
+	plainServerConfig
+	CookieExpiration string `json:"cookieExpiration"`
+}
+
+type plainServerConfig ServerConfig
+
+// MarshalJSON writes CookieExpiration as a duration string, e.g. "24h0m0s".
+func (s ServerConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(serverConfigJSON{plainServerConfig(s), s.CookieExpiration.String()})
+}
+
+// UnmarshalJSON reads the settings written by MarshalJSON.
+func (s *ServerConfig) UnmarshalJSON(data []byte) error {
+	var v serverConfigJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = ServerConfig(v.plainServerConfig)
+	if v.CookieExpiration != "" {
+		expiration, err := time.ParseDuration(v.CookieExpiration)
+		if err != nil {
+			return fmt.Errorf("cookieExpiration: %w", err)
+		}
+		s.CookieExpiration = expiration
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CONFIG_SIGNATURE_SUFFIX is appended to the name of a configuration file
+// (or URL) to get the name of its detached signature.
+const CONFIG_SIGNATURE_SUFFIX = ".sig"
+
+// CONFIG_MANIFEST_SIGNATURE is the name of the detached signature of the
+// manifest of a directory of fragments, in that directory.
+const CONFIG_MANIFEST_SIGNATURE = "fragments.sig"
+
+// WithTrustedKeys requires every configuration document to carry a detached
+// ed25519 signature made with one of keys.  Documents that are unsigned or
+// fail verification are rejected.
+func WithTrustedKeys(keys ...ed25519.PublicKey) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.requireSignature = true
+		h.trustedKeys = keys
+	}
+}
+
+// LoadPublicKeys reads the PEM-encoded ed25519 public keys in a file, as
+// written by `openssl pkey -pubout`.
+func LoadPublicKeys(path string) ([]ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParsePublicKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParsePublicKeys decodes PEM-encoded ed25519 public keys.  At least one key
+// is required.
+func ParsePublicKeys(data []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type %T, expected ed25519", key)
+		}
+		keys = append(keys, publicKey)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM-encoded public keys found")
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey decodes a PEM-encoded ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM-encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T, expected ed25519", key)
+	}
+	return privateKey, nil
+}
+
+// SignConfig returns the detached signature of a configuration document, to
+// be stored next to it with CONFIG_SIGNATURE_SUFFIX appended to its name.
+// The signature is base64-encoded, so that it can be kept in a ConfigMap.
+func SignConfig(data []byte, key ed25519.PrivateKey) []byte {
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return []byte(signature + "\n")
+}
+
+// verifySignature checks the detached signature of data against the trusted
+// keys.
+func verifySignature(data, signature []byte, keys []ed25519.PublicKey) error {
+	if signature == nil {
+		return errors.New("missing signature")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, data, decoded) {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any trusted key")
+}
+
+// verifyDocuments checks the signatures of configuration documents, or of
+// the manifest that lists them, if signatures are required.
+func (h *AuthzHandler) verifyDocuments(docs []configDocument, manifest *configDocument) error {
+	if !h.requireSignature {
+		return nil
+	}
+	if manifest != nil {
+		docs = []configDocument{*manifest}
+	}
+	var errs []error
+	for _, doc := range docs {
+		if err := verifySignature(doc.Data, doc.Signature, h.trustedKeys); err != nil {
+			errs = append(errs, documentError(doc.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readSignature reads the detached signature of the file at path, or
+// returns nil if there is none.
+func readSignature(path string) ([]byte, error) {
+	signature, err := os.ReadFile(path + CONFIG_SIGNATURE_SUFFIX)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return signature, err
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return public, private
+}
+
+func TestParseKeys(t *testing.T) {
+	public, private := newTestKey(t)
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newTestKey(t)
+	otherDER, _ := x509.MarshalPKIXPublicKey(other)
+
+	publicPEM := append(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherDER})...)
+	keys, err := ParsePublicKeys(publicPEM)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(public) || !keys[1].Equal(other) {
+		t.Errorf("Expected both public keys, got %v", keys)
+	}
+	if _, err := ParsePublicKeys([]byte("not a key")); err == nil {
+		t.Error("Expected error for input without keys, got nil")
+	}
+
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !parsed.Equal(private) {
+		t.Error("Expected parsed private key to match")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	public, private := newTestKey(t)
+	other, otherPrivate := newTestKey(t)
+	data := []byte("namespaces:\n  cool-otter:\n    target: blue\n")
+
+	type testCase struct {
+		name      string
+		data      []byte
+		signature []byte
+		keys      []ed25519.PublicKey
+		expected  string
+	}
+	tests := []testCase{
+		{"valid", data, SignConfig(data, private), []ed25519.PublicKey{public}, ""},
+		{"any trusted key", data, SignConfig(data, private), []ed25519.PublicKey{other, public}, ""},
+		{"missing", data, nil, []ed25519.PublicKey{public}, "missing signature"},
+		{"malformed", data, []byte("c2lnbmF0dXJl\n"), []ed25519.PublicKey{public}, "malformed signature"},
+		{"untrusted key", data, SignConfig(data, otherPrivate), []ed25519.PublicKey{public}, "does not match"},
+		{"tampered", append(data, '#'), SignConfig(data, private), []ed25519.PublicKey{public}, "does not match"},
+		{"no trusted keys", data, SignConfig(data, private), nil, "does not match"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifySignature(tc.data, tc.signature, tc.keys)
+			switch {
+			case tc.expected == "" && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)):
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestSignedConfig(t *testing.T) {
+	public, private := newTestKey(t)
+	blue := []byte("namespaces:\n  cool-otter:\n    target: blue\n")
+	red := []byte("namespaces:\n  cool-otter:\n    target: red\n")
+
+	t.Run("file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		writeFile := func(name string, data []byte) {
+			t.Helper()
+			if err := os.WriteFile(filepath.Join(tempDir, name), data, 0644); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		writeFile("config.yaml", blue)
+		writeFile("config.yaml.sig", SignConfig(blue, private))
+
+		handler := NewServerHandler("http://test", nil, WithConfig(configPath), WithTrustedKeys(public))
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Fatalf("Expected signed config to load, got target %q", target)
+		}
+
+		// changed without re-signing
+		writeFile("config.yaml", red)
+		if err := handler.loadConfig(); err == nil || !strings.Contains(err.Error(), "config.yaml: signature does not match") {
+			t.Errorf("Expected signature error, got %v", err)
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Errorf("Expected previous target blue to be kept, got %s", target)
+		}
+
+		writeFile("config.yaml.sig", SignConfig(red, private))
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "red" {
+			t.Errorf("Expected target red, got %s", target)
+		}
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		tempDir := t.TempDir()
+		writeFile := func(name string, data []byte) {
+			t.Helper()
+			if err := os.WriteFile(filepath.Join(tempDir, name), data, 0644); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		sign := func() []byte {
+			t.Helper()
+			manifest, err := ConfigManifest(tempDir)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return SignConfig(manifest, private)
+		}
+		writeFile("a.yaml", blue)
+		writeFile("b.yaml", []byte("namespaces:\n  happy-panda:\n    target: green\n"))
+
+		handler := NewServerHandler("http://test", nil, WithConfig(tempDir), WithTrustedKeys(public))
+		if handler.status().Generation != 0 {
+			t.Error("Expected unsigned fragments to be rejected")
+		}
+		if err := handler.loadConfig(); err == nil || !strings.Contains(err.Error(), "missing signature") {
+			t.Errorf("Expected missing signature error, got %v", err)
+		}
+
+		writeFile(CONFIG_MANIFEST_SIGNATURE, sign())
+		if err := handler.loadConfig(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// removing a fragment, or replacing it with another signed
+		// version, invalidates the signature
+		if err := os.Remove(filepath.Join(tempDir, "b.yaml")); err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.loadConfig(); err == nil || !strings.Contains(err.Error(), "signature does not match") {
+			t.Errorf("Expected signature error after removing a fragment, got %v", err)
+		}
+		writeFile(CONFIG_MANIFEST_SIGNATURE, sign())
+		writeFile("a.yaml", red)
+		if err := handler.loadConfig(); err == nil || !strings.Contains(err.Error(), "signature does not match") {
+			t.Errorf("Expected signature error after replacing a fragment, got %v", err)
+		}
+		if _, ok := handler.config().Namespaces["happy-panda"]; !ok {
+			t.Error("Expected the signed fragments to stay in effect")
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		signature := SignConfig(blue, private)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/config.yaml":
+				w.Write(blue)
+			case "/config.yaml.sig":
+				w.Write(signature)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+
+		handler := NewServerHandler("http://test", nil, WithConfigURL(ts.URL+"/config.yaml"), WithTrustedKeys(public))
+		if target := handler.config().Namespaces["cool-otter"].Target; target != "blue" {
+			t.Fatalf("Expected signed remote config to load, got target %q", target)
+		}
+
+		signature = SignConfig(red, private)
+		handler.remote.etag = ""
+		if err := handler.loadConfig(); err == nil || !strings.Contains(err.Error(), "signature does not match") {
+			t.Errorf("Expected signature error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/michaelw/ext-authz-router/db"
+)
+
+// SQLiteNamespaceStore keeps namespaces in an embedded SQLite database.
+// Lookups query the database directly, so it can hold many more namespaces
+// than fit comfortably in a ConfigMap, and changes made by other processes
+// (such as the store import command) take effect immediately.
+type SQLiteNamespaceStore struct {
+	conn    *sql.DB
+	queries *db.Queries
+}
+
+// OpenSQLiteNamespaceStore opens (or creates) the database at path and
+// brings its schema up to date.
+func OpenSQLiteNamespaceStore(ctx context.Context, path string) (*SQLiteNamespaceStore, error) {
+	dsn := (&url.URL{
+		Scheme:   "file",
+		Path:     path,
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)",
+	}).String()
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Migrate(ctx, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &SQLiteNamespaceStore{conn: conn, queries: db.New(conn)}, nil
+}
+
+// OpenNamespaceStore opens the store at path: a SQLite database for the
+// extensions .db, .sqlite and .sqlite3, a YAML file otherwise.
+func OpenNamespaceStore(ctx context.Context, path string) (NamespaceStore, error) {
+	switch filepath.Ext(path) {
+	case ".db", ".sqlite", ".sqlite3":
+		return OpenSQLiteNamespaceStore(ctx, path)
+	}
+	return NewFileNamespaceStore(path), nil
+}
+
+// Close closes the database.
+func (s *SQLiteNamespaceStore) Close() error {
+	return s.conn.Close()
+}
+
+func (s *SQLiteNamespaceStore) Load(ctx context.Context) (map[string]NamespaceConfig, error) {
+	rows, err := s.queries.ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]NamespaceConfig, len(rows))
+	for _, row := range rows {
+		namespaces[row.ID] = namespaceFromRow(row)
+	}
+	return namespaces, nil
+}
+
+// Get returns a single namespace.
+func (s *SQLiteNamespaceStore) Get(ctx context.Context, id string) (NamespaceConfig, bool, error) {
+	row, err := s.queries.GetNamespace(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NamespaceConfig{}, false, nil
+	}
+	if err != nil {
+		return NamespaceConfig{}, false, err
+	}
+	return namespaceFromRow(row), true, nil
+}
+
+func (s *SQLiteNamespaceStore) Put(ctx context.Context, id string, ns NamespaceConfig) error {
+	return s.queries.UpsertNamespace(ctx, upsertParams(id, ns))
+}
+
+func (s *SQLiteNamespaceStore) PutAll(ctx context.Context, namespaces map[string]NamespaceConfig) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := s.queries.WithTx(tx)
+	for id, ns := range namespaces {
+		if err := queries.UpsertNamespace(ctx, upsertParams(id, ns)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteNamespaceStore) Delete(ctx context.Context, id string) error {
+	return s.queries.DeleteNamespace(ctx, id)
+}
+
+func upsertParams(id string, ns NamespaceConfig) db.UpsertNamespaceParams {
+	params := db.UpsertNamespaceParams{
+		ID:          id,
+		Target:      ns.Target,
+		Description: ns.Description,
+		State:       ns.State,
+		Restricted:  ns.Restricted,
+	}
+	if ns.ExpiresAt != nil {
+		params.ExpiresAt = sql.NullTime{Time: ns.ExpiresAt.UTC(), Valid: true}
+	}
+	return params
+}
+
+func namespaceFromRow(row db.Namespace) NamespaceConfig {
+	ns := NamespaceConfig{
+		Target:      row.Target,
+		Description: row.Description,
+		State:       row.State,
+		Restricted:  row.Restricted,
+	}
+	if row.ExpiresAt.Valid {
+		expiresAt := row.ExpiresAt.Time.UTC()
+		ns.ExpiresAt = &expiresAt
+	}
+	return ns
+}
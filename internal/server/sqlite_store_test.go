@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func openTestStore(t *testing.T, path string) *SQLiteNamespaceStore {
+	t.Helper()
+	store, err := OpenSQLiteNamespaceStore(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteNamespaceStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "namespaces.db")
+	store := openTestStore(t, path)
+
+	if err := store.Put(ctx, "pr-123", NamespaceConfig{Target: "pr-123", Description: "PR 123"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(ctx, "pr-123", NamespaceConfig{Target: "pr-123-v2"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(ctx, "pr-456", NamespaceConfig{Target: "pr-456"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	ns, ok, err := store.Get(ctx, "pr-123")
+	if err != nil || !ok || ns.Target != "pr-123-v2" || ns.Description != "" {
+		t.Errorf("Expected updated pr-123, got %+v, %v, %v", ns, ok, err)
+	}
+	if _, ok, err := store.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("Expected missing namespace to not be found, got %v, %v", ok, err)
+	}
+
+	if err := store.Delete(ctx, "pr-456"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete(ctx, "pr-456"); err != nil {
+		t.Errorf("Expected deleting a missing namespace to succeed, got %v", err)
+	}
+
+	// reopening keeps the data and does not reapply migrations
+	store.Close()
+	reopened := openTestStore(t, path)
+	namespaces, err := reopened.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(namespaces) != 1 || namespaces["pr-123"].Target != "pr-123-v2" {
+		t.Errorf("Expected only pr-123, got %v", namespaces)
+	}
+
+	if _, err := reopened.conn.Exec("PRAGMA user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+	if _, err := OpenSQLiteNamespaceStore(ctx, path); err == nil || !strings.Contains(err.Error(), "newer than this release") {
+		t.Errorf("Expected error for a newer schema, got %v", err)
+	}
+}
+
+func TestSQLiteNamespaceStoreLookups(t *testing.T) {
+	const config = `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`
+	path := filepath.Join(t.TempDir(), "namespaces.db")
+	store := openTestStore(t, path)
+	handler := newTestHandler(t, config, WithNamespaceStore(store))
+	router := newAdminRouter(t, handler)
+	authz := NewAuthzGRPCServer(handler)
+
+	if rec := adminRequest(router, http.MethodPut, "/admin/namespaces/pr-123", "secret", `{"target": "pr-123"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := handler.config().Namespaces["pr-123"]; ok {
+		t.Error("Expected namespaces from the database not to be held in memory")
+	}
+
+	// written by another process, e.g. the store import command
+	other := openTestStore(t, path)
+	if err := other.Put(context.Background(), "pr-456", NamespaceConfig{Target: "pr-456"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for id, target := range map[string]string{"cool-otter": "blue", "pr-123": "pr-123", "pr-456": "pr-456"} {
+		resp, err := authz.Check(context.Background(), newCheckRequest("app.test", "/", map[string]string{"x-namespace": id}))
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		if codes.Code(resp.Status.Code) != codes.OK || responseHeaders(resp)["x-backend"] != target {
+			t.Errorf("Expected %s to route to %s, got %v", id, target, resp)
+		}
+	}
+
+	rec := adminRequest(router, http.MethodGet, "/admin/namespaces", "secret", "")
+	for _, id := range []string{"cool-otter", "pr-123", "pr-456"} {
+		if !strings.Contains(rec.Body.String(), `"`+id+`"`) {
+			t.Errorf("Expected %s in the list, got %s", id, rec.Body)
+		}
+	}
+
+	if rec := adminRequest(router, http.MethodDelete, "/admin/namespaces/pr-456", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	resp, _ := authz.Check(context.Background(), newCheckRequest("app.test", "/", map[string]string{"x-namespace": "pr-456"}))
+	if codes.Code(resp.Status.Code) != codes.PermissionDenied {
+		t.Errorf("Expected deleted namespace to be denied, got %v", resp.Status)
+	}
+}
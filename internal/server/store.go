@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NAMESPACE_SOURCE_ADMIN is the source of namespaces managed through the
+// admin API.
+const NAMESPACE_SOURCE_ADMIN = "admin"
+
+// NamespaceStore persists the namespaces managed through the admin API.
+type NamespaceStore interface {
+	// Load returns all stored namespaces.
+	Load(ctx context.Context) (map[string]NamespaceConfig, error)
+	// Put creates or replaces a namespace.
+	Put(ctx context.Context, id string, ns NamespaceConfig) error
+	// PutAll creates or replaces several namespaces at once, so that no
+	// reader sees some of them changed and others not.
+	PutAll(ctx context.Context, namespaces map[string]NamespaceConfig) error
+	// Delete removes a namespace.  Deleting a namespace that is not stored
+	// is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// NamespaceReader is implemented by stores that serve lookups directly.
+// Their namespaces are not held in memory, but read on every lookup.
+type NamespaceReader interface {
+	NamespaceStore
+	// Get returns a single namespace and whether it exists.
+	Get(ctx context.Context, id string) (NamespaceConfig, bool, error)
+}
+
+// WithNamespaceStore sets where namespaces managed through the admin API are
+// persisted.
+func WithNamespaceStore(store NamespaceStore) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.store = store
+	}
+}
+
+// FileNamespaceStore keeps namespaces in a YAML file in the configuration
+// format, so that it can be turned into a ConfigMap.  The file is replaced
+// atomically on every change.
+type FileNamespaceStore struct {
+	path string
+
+	lock       sync.Mutex
+	namespaces map[string]NamespaceConfig
+}
+
+// NewFileNamespaceStore returns a store backed by the file at path, which
+// need not exist yet.
+func NewFileNamespaceStore(path string) *FileNamespaceStore {
+	return &FileNamespaceStore{path: path}
+}
+
+func (s *FileNamespaceStore) Load(ctx context.Context) (map[string]NamespaceConfig, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	return maps.Clone(s.namespaces), nil
+}
+
+func (s *FileNamespaceStore) Put(ctx context.Context, id string, ns NamespaceConfig) error {
+	return s.PutAll(ctx, map[string]NamespaceConfig{id: ns})
+}
+
+func (s *FileNamespaceStore) PutAll(ctx context.Context, changed map[string]NamespaceConfig) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.read(); err != nil {
+		return err
+	}
+	namespaces := maps.Clone(s.namespaces)
+	maps.Copy(namespaces, changed)
+	return s.write(namespaces)
+}
+
+func (s *FileNamespaceStore) Delete(ctx context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.read(); err != nil {
+		return err
+	}
+	if _, ok := s.namespaces[id]; !ok {
+		return nil
+	}
+	namespaces := maps.Clone(s.namespaces)
+	delete(namespaces, id)
+	return s.write(namespaces)
+}
+
+// read loads the file on first use.
+func (s *FileNamespaceStore) read() error {
+	if s.namespaces != nil {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.namespaces = map[string]NamespaceConfig{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return documentError(s.path, err)
+	}
+	s.namespaces = map[string]NamespaceConfig{}
+	for id, ns := range cfg.Namespaces {
+		ns.Source = ""
+		s.namespaces[id] = ns
+	}
+	return nil
+}
+
+// write replaces the file with namespaces.
+func (s *FileNamespaceStore) write(namespaces map[string]NamespaceConfig) error {
+	data, err := MarshalNamespaces(namespaces)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.namespaces = namespaces
+	return nil
+}
+
+// namespace looks up a namespace in cfg and then, if namespaces are read
+// from the store directly, in the store.  Stored namespaces take precedence
+// over discovered ones.  Catalogs hold static namespaces only.
+func (h *AuthzHandler) namespace(ctx context.Context, cfg AuthzConfig, id string) (NamespaceConfig, bool) {
+	static, found := cfg.Namespaces[id]
+	if found && !static.isDiscovered() {
+		return static, true
+	}
+	reader, ok := h.store.(NamespaceReader)
+	if !ok || cfg.catalog != "" {
+		return static, found
+	}
+	ns, ok, err := reader.Get(ctx, id)
+	if err != nil {
+		log.Printf("E: [store] looking up namespace %s: %v", id, err)
+		return NamespaceConfig{}, false
+	}
+	if !ok {
+		return static, found
+	}
+	ns.Source = NAMESPACE_SOURCE_ADMIN
+	return ns, true
+}
+
+// namespaces returns the namespaces in cfg and, if namespaces are read from
+// the store directly, those in the store.
+func (h *AuthzHandler) namespaces(ctx context.Context, cfg AuthzConfig) map[string]NamespaceConfig {
+	reader, ok := h.store.(NamespaceReader)
+	if !ok || cfg.catalog != "" {
+		return cfg.Namespaces
+	}
+	stored, err := reader.Load(ctx)
+	if err != nil {
+		log.Printf("E: [store] listing namespaces: %v", err)
+		return cfg.Namespaces
+	}
+	namespaces := maps.Clone(cfg.Namespaces)
+	if namespaces == nil {
+		namespaces = map[string]NamespaceConfig{}
+	}
+	for id, ns := range stored {
+		if static, ok := namespaces[id]; !ok || static.isDiscovered() {
+			ns.Source = NAMESPACE_SOURCE_ADMIN
+			namespaces[id] = ns
+		}
+	}
+	return namespaces
+}
+
+// MarshalNamespaces encodes namespaces as a configuration document, the
+// format of FileNamespaceStore and of store exports.
+func MarshalNamespaces(namespaces map[string]NamespaceConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(AuthzConfig{
+		APIVersion: CONFIG_API_VERSION,
+		Namespaces: namespaces,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"crypto/ed25519"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/getkin/kin-openapi/openapi3"
 
@@ -11,10 +14,69 @@ import (
 var _ api.StrictServerInterface = (*AuthzHandler)(nil)
 
 type AuthzConfig struct {
-	Namespaces map[string]struct {
-		Target      string `yaml:"target" json:"target"`
-		Description string `yaml:"description,omitempty" json:"description,omitempty"`
-	} `yaml:"namespaces" json:"namespaces"`
+	APIVersion string                     `yaml:"apiVersion" json:"apiVersion"`
+	Server     ServerConfig               `yaml:"server,omitempty" json:"server"`
+	Defaults   *NamespaceConfig           `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	Templates  map[string]NamespaceConfig `yaml:"templates,omitempty" json:"templates,omitempty"`
+	Namespaces map[string]NamespaceConfig `yaml:"namespaces" json:"namespaces"`
+	// Catalogs replace the namespaces for requests to the hosts matching
+	// their key, a host name or glob such as *.shop.int.kube.
+	Catalogs map[string]CatalogConfig `yaml:"catalogs,omitempty" json:"catalogs,omitempty"`
+	// Patterns define the namespaces whose IDs match them, in order.
+	// Namespaces defined explicitly take precedence.
+	Patterns []NamespacePattern `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+
+	status  ConfigStatus
+	secrets []string // values read from files, redacted in dumps
+	// catalog is the key of the catalog whose namespaces this snapshot
+	// holds and cookieName its namespace cookie, see forHost.
+	catalog    string
+	cookieName string
+}
+
+// CatalogConfig holds the namespaces of one application.  Namespaces
+// inherit the defaults and templates like top-level ones.
+type CatalogConfig struct {
+	// Title is shown by the namespace selector.
+	Title string `yaml:"title,omitempty" json:"title,omitempty"`
+	// CookieName replaces the server's cookieName for the catalog, see
+	// cookieName.
+	CookieName string                     `yaml:"cookieName,omitempty" json:"cookieName,omitempty"`
+	Namespaces map[string]NamespaceConfig `yaml:"namespaces" json:"namespaces"`
+}
+
+// ServerConfig holds the routing settings.  Each setting can be overridden
+// by the environment variable of the same name as its default.
+type ServerConfig struct {
+	CookieName       string        `yaml:"cookieName,omitempty" json:"cookieName"`
+	CookieDomain     string        `yaml:"cookieDomain,omitempty" json:"cookieDomain"`
+	CookieExpiration time.Duration `yaml:"cookieExpiration,omitempty" json:"cookieExpiration"`
+	RedirectURL      string        `yaml:"redirectURL,omitempty" json:"redirectURL"`
+	BackendHeader    string        `yaml:"backendHeader,omitempty" json:"backendHeader"`
+	// Extractors are tried in order to find the namespace of a request.
+	Extractors []Extractor `yaml:"extractors,omitempty" json:"extractors"`
+
+	ExposeConfigGeneration bool `yaml:"exposeConfigGeneration,omitempty" json:"exposeConfigGeneration"`
+}
+
+// NamespaceConfig holds the settings of a namespace.  Namespaces inherit
+// settings from the defaults and from the template they extend, if any.
+type NamespaceConfig struct {
+	Extends     string `yaml:"extends,omitempty" json:"extends,omitempty"`
+	Target      string `yaml:"target,omitempty" json:"target"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// ExpiresAt is when the namespace stops being offered and routed to.
+	ExpiresAt *time.Time `yaml:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// State is the lifecycle state, see NAMESPACE_STATE_ACTIVE and friends.
+	State string `yaml:"state,omitempty" json:"state,omitempty"`
+	// Restricted namespaces are only routed to for clients with an
+	// approved access request.
+	Restricted bool `yaml:"restricted,omitempty" json:"restricted,omitempty"`
+
+	// Source is the configuration document the namespace was defined in.
+	Source string `yaml:"-" json:"source,omitempty"`
+	// pattern is the pattern the namespace was matched by, if any.
+	pattern string
 }
 
 type AuthzHandler struct {
@@ -22,5 +84,45 @@ type AuthzHandler struct {
 	Swagger       *openapi3.T
 	configLock    sync.RWMutex
 	currentConfig AuthzConfig
+	configLoaded  bool
 	configPath    string
+	configURL     string
+	remote        *remoteConfig
+
+	requireSignature bool
+	trustedKeys      []ed25519.PublicKey
+
+	// managed holds the namespaces managed through the admin API
+	managed         map[string]NamespaceConfig
+	store           NamespaceStore
+	adminTokensPath string
+	auditLog        io.Writer
+	janitorInterval time.Duration
+	promotions      map[string]*promotion
+
+	reservationLock sync.Mutex
+	reservations    map[catalogNamespace]reservation
+
+	github *githubWebhook
+
+	// discovered holds the namespaces discovered from Kubernetes
+	discovery       *discovery
+	discovered      map[string]NamespaceConfig
+	discoveryLoaded bool
+
+	accessLock     sync.Mutex
+	accessRequests map[string]*accessRequest
+
+	// patternsSeen records when namespaces matched by patterns were last
+	// used, see seePattern
+	patternLock  sync.Mutex
+	patternsSeen map[string]time.Time
+
+	eventListeners []EventListener
+	webhooks       *webhooks
+
+	// reloadLock serializes loads, so that they are applied in order
+	reloadLock     sync.Mutex
+	reloadDebounce time.Duration
+	pollInterval   time.Duration
 }
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration for settings that would break routing
+// and returns lint warnings for settings that work but are likely mistakes.
+// All problems are reported at once, joined into a single error.
+func (cfg *AuthzConfig) Validate() (warnings []string, err error) {
+	errs := cfg.Server.validate()
+
+	if len(cfg.Namespaces) == 0 && len(cfg.Catalogs) == 0 && len(cfg.Patterns) == 0 {
+		warnings = append(warnings, "namespaces: no namespaces configured, all requests will be denied")
+	}
+	if len(cfg.Server.Extractors) > 0 && !readsCookie(cfg.Server.Extractors, cfg.Server.CookieName) {
+		warnings = append(warnings, fmt.Sprintf("server.extractors: no cookie extractor reads the %q cookie set by the namespace selector, browsers without a namespace get 401 instead of a redirect", cfg.Server.CookieName))
+	}
+	warnings, errs = validateNamespaces("namespaces", cfg.Namespaces, warnings, errs)
+	errs = append(errs, validatePatterns(cfg.Patterns)...)
+
+	for _, key := range slices.Sorted(maps.Keys(cfg.Catalogs)) {
+		catalog := cfg.Catalogs[key]
+		path := "catalogs." + key
+		if err := validateHostGlob(key); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+		if catalog.CookieName != "" && !isToken(catalog.CookieName) {
+			errs = append(errs, fmt.Errorf("%s.cookieName: %q is not a valid cookie name", path, catalog.CookieName))
+		}
+		if len(catalog.Namespaces) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s.namespaces: no namespaces configured, requests to matching hosts will be denied", path))
+		}
+		warnings, errs = validateNamespaces(path+".namespaces", catalog.Namespaces, warnings, errs)
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+// validateNamespaces checks the namespaces in a section, adding to warnings and
+// errs.
+func validateNamespaces(section string, namespaces map[string]NamespaceConfig, warnings []string, errs []error) ([]string, []error) {
+	now := time.Now()
+	byTarget := map[string][]string{}
+	for _, id := range slices.Sorted(maps.Keys(namespaces)) {
+		ns := namespaces[id]
+		path := section + "." + id
+
+		if err := validateNamespaceID(id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+
+		if ns.Target == "" {
+			errs = append(errs, fmt.Errorf("%s.target: must not be empty", path))
+		} else if err := validateHeaderValue(ns.Target); err != nil {
+			errs = append(errs, fmt.Errorf("%s.target: %w", path, err))
+		} else {
+			byTarget[ns.Target] = append(byTarget[ns.Target], id)
+		}
+
+		if ns.Description == "" {
+			warnings = append(warnings, fmt.Sprintf("%s.description: missing, the namespace ID is shown instead", path))
+		}
+
+		if err := validateState(ns.State); err != nil {
+			errs = append(errs, fmt.Errorf("%s.state: %w", path, err))
+		}
+
+		if ns.expired(now) {
+			warnings = append(warnings, fmt.Sprintf("%s.expiresAt: expired at %s, the namespace is not routed to", path, ns.ExpiresAt.Format(time.RFC3339)))
+		}
+	}
+
+	for _, target := range slices.Sorted(maps.Keys(byTarget)) {
+		if ids := byTarget[target]; len(ids) > 1 {
+			warnings = append(warnings, fmt.Sprintf("%s: %s share target %q", section, strings.Join(ids, ", "), target))
+		}
+	}
+	return warnings, errs
+}
+
+// validateNamespaceID checks that id can be stored in the namespace cookie.
+func validateNamespaceID(id string) error {
+	if id == "" {
+		return errors.New("namespace ID must not be empty")
+	}
+	for _, r := range id {
+		if !isCookieOctet(r) {
+			return fmt.Errorf("namespace ID contains %q, which is not allowed in cookie values", r)
+		}
+	}
+	return nil
+}
+
+// isCookieOctet reports whether r may appear in a cookie value (RFC 6265,
+// section 4.1.1).
+func isCookieOctet(r rune) bool {
+	return r == 0x21 ||
+		(r >= 0x23 && r <= 0x2B) ||
+		(r >= 0x2D && r <= 0x3A) ||
+		(r >= 0x3C && r <= 0x5B) ||
+		(r >= 0x5D && r <= 0x7E)
+}
+
+// validateHeaderValue checks that s can be sent as an HTTP header value.
+func validateHeaderValue(s string) error {
+	if strings.TrimSpace(s) != s {
+		return errors.New("must not have leading or trailing whitespace")
+	}
+	for _, r := range s {
+		if r != '\t' && (r < 0x20 || r > 0x7E) {
+			return fmt.Errorf("contains %q, which is not allowed in HTTP header values", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type testCase struct {
+		name     string
+		config   string
+		errors   []string
+		warnings []string
+	}
+
+	tests := []testCase{
+		{
+			name: "valid config",
+			config: `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`,
+		},
+		{
+			name: "unknown state",
+			config: `
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+    state: paused
+`,
+			errors: []string{`namespaces.cool-otter.state: unknown state "paused"`},
+		},
+		{
+			name: "unknown key",
+			config: `
+namespaces:
+  cool-otter:
+    taget: blue
+`,
+			errors: []string{"field taget not found"},
+		},
+		{
+			name: "empty target",
+			config: `
+namespaces:
+  cool-otter:
+    target: ""
+    description: Cool Otter
+`,
+			errors: []string{"namespaces.cool-otter.target: must not be empty"},
+		},
+		{
+			name: "illegal characters",
+			config: `
+namespaces:
+  "cool otter;":
+    target: "blue\n"
+    description: Cool Otter
+`,
+			errors: []string{
+				`namespaces.cool otter;: namespace ID contains ' '`,
+				"namespaces.cool otter;.target: must not have leading or trailing whitespace",
+			},
+		},
+		{
+			name: "lint warnings",
+			config: `
+namespaces:
+  cool-otter:
+    target: blue
+  awesome-penguin:
+    target: blue
+    description: Awesome Penguin
+`,
+			warnings: []string{
+				"namespaces.cool-otter.description: missing",
+				`namespaces: awesome-penguin, cool-otter share target "blue"`,
+			},
+		},
+		{
+			name: "invalid server settings",
+			config: `
+server:
+  cookieName: "name space"
+  redirectURL: /relative
+  backendHeader: "x:backend"
+namespaces:
+  cool-otter:
+    target: blue
+    description: Cool Otter
+`,
+			errors: []string{
+				`server.cookieName: "name space" is not a valid cookie name`,
+				`server.redirectURL: "/relative" is not an absolute URL`,
+				`server.backendHeader: "x:backend" is not a valid header name`,
+			},
+		},
+		{
+			name:     "empty config",
+			config:   ``,
+			warnings: []string{"no namespaces configured"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var warnings []string
+			cfg, err := ParseConfig([]byte(tc.config))
+			if err == nil {
+				warnings, err = cfg.Validate()
+			}
+
+			if len(tc.errors) == 0 && err != nil {
+				t.Errorf("Expected config to be valid, got: %v", err)
+			}
+			for _, expected := range tc.errors {
+				if err == nil || !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected error containing %q, got: %v", expected, err)
+				}
+			}
+
+			if len(warnings) != len(tc.warnings) {
+				t.Errorf("Expected %d warnings, got %d: %v", len(tc.warnings), len(warnings), warnings)
+			}
+			for _, expected := range tc.warnings {
+				found := false
+				for _, warning := range warnings {
+					found = found || strings.Contains(warning, expected)
+				}
+				if !found {
+					t.Errorf("Expected warning containing %q, got: %v", expected, warnings)
+				}
+			}
+		})
+	}
+}
+
+func loadConfigSchema(t *testing.T) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile("../../api/config.schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read config schema: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Failed to parse config schema: %v", err)
+	}
+	return schema
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := loadConfigSchema(t)
+	defs := schema["$defs"].(map[string]any)
+
+	resolve := func(node map[string]any) map[string]any {
+		for {
+			ref, ok := node["$ref"].(string)
+			if !ok {
+				return node
+			}
+			node = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		}
+	}
+
+	// Every YAML field of the configuration types must be described by the
+	// schema, so that editor validation does not reject valid files.
+	var walk func(path string, typ reflect.Type, node map[string]any)
+	walk = func(path string, typ reflect.Type, node map[string]any) {
+		node = resolve(node)
+		switch typ.Kind() {
+		case reflect.Pointer:
+			walk(path, typ.Elem(), node)
+		case reflect.Map:
+			values, ok := node["additionalProperties"].(map[string]any)
+			if !ok {
+				t.Errorf("%s: schema does not describe map values", path)
+				return
+			}
+			walk(path+".*", typ.Elem(), values)
+		case reflect.Slice:
+			items, ok := node["items"].(map[string]any)
+			if !ok {
+				t.Errorf("%s: schema does not describe list items", path)
+				return
+			}
+			walk(path+"[]", typ.Elem(), items)
+		case reflect.Struct:
+			properties, _ := node["properties"].(map[string]any)
+			for i := range typ.NumField() {
+				field := typ.Field(i)
+				name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+				if name == "-" || !field.IsExported() {
+					continue
+				}
+				if name == "" {
+					walk(path, field.Type, node)
+					continue
+				}
+				property, ok := properties[name].(map[string]any)
+				if !ok {
+					t.Errorf("%s.%s: missing from config schema", path, name)
+					continue
+				}
+				walk(path+"."+name, field.Type, property)
+			}
+		}
+	}
+	walk("$", reflect.TypeFor[AuthzConfig](), schema)
+
+	versions := schema["properties"].(map[string]any)["apiVersion"].(map[string]any)["enum"].([]any)
+	if versions[0] != CONFIG_API_VERSION {
+		t.Errorf("Expected %s as the first apiVersion in the config schema, got %v", CONFIG_API_VERSION, versions[0])
+	}
+
+	// The schema patterns must agree with the checks in Validate.
+	idPattern := regexp.MustCompile(resolve(defs["namespaceID"].(map[string]any))["pattern"].(string))
+	headerPattern := regexp.MustCompile(resolve(defs["headerValue"].(map[string]any))["pattern"].(string))
+	tokenPattern := regexp.MustCompile(resolve(defs["token"].(map[string]any))["pattern"].(string))
+	for c := range rune(0x80) {
+		s := "a" + string(c) + "a"
+		if idPattern.MatchString(s) != (validateNamespaceID(s) == nil) {
+			t.Errorf("Namespace ID pattern disagrees with validateNamespaceID for %q", c)
+		}
+		if headerPattern.MatchString(s) != (validateHeaderValue(s) == nil) {
+			t.Errorf("Header value pattern disagrees with validateHeaderValue for %q", c)
+		}
+		if tokenPattern.MatchString(s) != isToken(s) {
+			t.Errorf("Token pattern disagrees with isToken for %q", c)
+		}
+	}
+}
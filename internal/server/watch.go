@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WithReloadDebounce sets how long the config watcher waits for file system
+// events to settle before reloading the configuration.
+func WithReloadDebounce(d time.Duration) HandlerOption {
+	return func(h *AuthzHandler) {
+		h.reloadDebounce = d
+	}
+}
+
+// WatchConfig reloads the configuration whenever the config file (or a
+// fragment in the config directory) changes or the process receives SIGHUP,
+// until ctx is cancelled.
+//
+// A config file is watched through its parent directory, so that
+// Kubernetes ConfigMap updates (an atomic swap of the ..data symlink) and
+// editors that replace files via rename are picked up.  Bursts of events are
+// debounced into a single reload.  A configuration that fails to load is
+// logged and the last good one stays in effect.
+//
+// A configuration URL is polled instead, see pollConfig.
+func (h *AuthzHandler) WatchConfig(ctx context.Context) error {
+	if h.remote != nil {
+		return h.pollConfig(ctx)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dir := h.configPath
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	log.Printf("I: watching %v for configuration changes", dir)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	debounce := time.NewTimer(h.reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			debounce.Reset(h.reloadDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("E: [config] watcher: %v", err)
+		case <-hup:
+			log.Println("I: [config] SIGHUP received, reloading")
+			h.reloadConfig()
+		case <-debounce.C:
+			h.reloadConfig()
+		}
+	}
+}
+
+// reloadConfig loads the configuration, keeping the current one on failure.
+func (h *AuthzHandler) reloadConfig() error {
+	err := h.loadConfig()
+	if err != nil {
+		log.Printf("E: [config] reload failed, keeping previous configuration: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForTarget(t *testing.T, handler *AuthzHandler, namespace, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		handler.configLock.RLock()
+		target := handler.currentConfig.Namespaces[namespace].Target
+		handler.configLock.RUnlock()
+		if target == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for namespace %s to have target %s", namespace, expected)
+}
+
+func TestWatchConfig(t *testing.T) {
+	t.Run("file rewritten in place", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		if err := os.WriteFile(configPath, []byte("namespaces:\n  cool-otter:\n    target: blue\n"), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+
+		handler := NewServerHandler("http://test", nil, WithConfig(configPath), WithReloadDebounce(10*time.Millisecond))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.WatchConfig(ctx)
+		time.Sleep(50 * time.Millisecond) // let the watcher start
+
+		if err := os.WriteFile(configPath, []byte("namespaces:\n  cool-otter:\n    target: red\n"), 0644); err != nil {
+			t.Fatalf("Failed to rewrite test config: %v", err)
+		}
+		waitForTarget(t, handler, "cool-otter", "red")
+	})
+
+	t.Run("invalid update keeps last good config", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		if err := os.WriteFile(configPath, []byte("namespaces:\n  cool-otter:\n    target: blue\n"), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+
+		handler := NewServerHandler("http://test", nil, WithConfig(configPath), WithReloadDebounce(10*time.Millisecond))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.WatchConfig(ctx)
+		time.Sleep(50 * time.Millisecond)
+
+		if err := os.WriteFile(configPath, []byte("invalid yaml content: [[["), 0644); err != nil {
+			t.Fatalf("Failed to rewrite test config: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		waitForTarget(t, handler, "cool-otter", "blue")
+
+		if err := os.WriteFile(configPath, []byte("namespaces:\n  cool-otter:\n    target: green\n"), 0644); err != nil {
+			t.Fatalf("Failed to rewrite test config: %v", err)
+		}
+		waitForTarget(t, handler, "cool-otter", "green")
+	})
+
+	t.Run("ConfigMap symlink swap", func(t *testing.T) {
+		// Mimic the layout kubelet uses for ConfigMap volumes:
+		//   config.yaml -> ..data/config.yaml, ..data -> ..<timestamp>
+		tempDir := t.TempDir()
+		writeVersion := func(name, target string) {
+			versionDir := filepath.Join(tempDir, name)
+			if err := os.Mkdir(versionDir, 0755); err != nil {
+				t.Fatalf("Failed to create version dir: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(versionDir, "config.yaml"), []byte("namespaces:\n  cool-otter:\n    target: "+target+"\n"), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+			if err := os.Symlink(name, filepath.Join(tempDir, "..data_tmp")); err != nil {
+				t.Fatalf("Failed to create symlink: %v", err)
+			}
+			if err := os.Rename(filepath.Join(tempDir, "..data_tmp"), filepath.Join(tempDir, "..data")); err != nil {
+				t.Fatalf("Failed to swap symlink: %v", err)
+			}
+		}
+		writeVersion("..v1", "blue")
+		configPath := filepath.Join(tempDir, "config.yaml")
+		if err := os.Symlink(filepath.Join("..data", "config.yaml"), configPath); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		handler := NewServerHandler("http://test", nil, WithConfig(configPath), WithReloadDebounce(10*time.Millisecond))
+		waitForTarget(t, handler, "cool-otter", "blue")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.WatchConfig(ctx)
+		time.Sleep(50 * time.Millisecond)
+
+		writeVersion("..v2", "yellow")
+		waitForTarget(t, handler, "cool-otter", "yellow")
+	})
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbound webhooks deliver every event as JSON to the configured URLs.
+const (
+	WEBHOOK_TIMEOUT      = 10 * time.Second
+	WEBHOOK_MAX_ATTEMPTS = 5
+	// WEBHOOK_BACKOFF is the delay before the first retry, doubled after
+	// each further failed attempt.
+	WEBHOOK_BACKOFF = time.Second
+	// WEBHOOK_QUEUE_SIZE is the number of events held per URL while a
+	// delivery is in progress.  Further events are dropped.
+	WEBHOOK_QUEUE_SIZE = 100
+
+	WEBHOOK_EVENT_HEADER     = "X-Authz-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Authz-Delivery"
+	WEBHOOK_SIGNATURE_HEADER = "X-Authz-Signature-256"
+)
+
+// WebhookConfig configures outbound webhooks.
+type WebhookConfig struct {
+	URLs []string
+	// SecretPath is a file holding the HMAC-SHA256 key that deliveries are
+	// signed with.  It is read on every delivery, so the secret can be
+	// rotated without a restart.
+	SecretPath string
+	// Backoff overrides WEBHOOK_BACKOFF.
+	Backoff time.Duration
+}
+
+// webhooks delivers events to outbound webhooks, one queue per URL, so that
+// a slow receiver does not hold up the others.
+type webhooks struct {
+	secretPath string
+	backoff    time.Duration
+	client     *http.Client
+	queues     map[string]chan webhookDelivery
+}
+
+// webhookDelivery is an event to be delivered, encoded once for all
+// attempts.
+type webhookDelivery struct {
+	id, event string
+	body      []byte
+}
+
+// WithWebhooks delivers events to outbound webhooks, see RunWebhooks.
+func WithWebhooks(cfg WebhookConfig) HandlerOption {
+	return func(h *AuthzHandler) {
+		w, err := newWebhooks(cfg)
+		if err != nil {
+			log.Printf("E: outbound webhooks disabled: %v", err)
+			return
+		}
+		h.webhooks = w
+		h.eventListeners = append(h.eventListeners, w.enqueue)
+	}
+}
+
+func newWebhooks(cfg WebhookConfig) (*webhooks, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no URLs")
+	}
+	if cfg.SecretPath == "" {
+		return nil, errors.New("no secret")
+	}
+	w := &webhooks{
+		secretPath: cfg.SecretPath,
+		backoff:    cfg.Backoff,
+		client:     &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		queues:     map[string]chan webhookDelivery{},
+	}
+	if w.backoff <= 0 {
+		w.backoff = WEBHOOK_BACKOFF
+	}
+	var errs []error
+	for _, rawURL := range cfg.URLs {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%q is not an http(s) URL", rawURL))
+			continue
+		}
+		w.queues[rawURL] = make(chan webhookDelivery, WEBHOOK_QUEUE_SIZE)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return w, nil
+}
+
+// splitURLs splits a comma- or space-separated list of URLs.
+func splitURLs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	})
+}
+
+// enqueue queues an event for delivery to every URL, dropping it for URLs
+// whose queue is full.
+func (w *webhooks) enqueue(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("E: [webhook] %v", err)
+		return
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("E: [webhook] %v", err)
+		return
+	}
+	id := hex.EncodeToString(b)
+	delivery := webhookDelivery{id: id, event: event.Type, body: body}
+	for u, queue := range w.queues {
+		select {
+		case queue <- delivery:
+		default:
+			log.Printf("E: [webhook] %s: queue full, dropping %s event %s", u, event.Type, id)
+		}
+	}
+}
+
+// RunWebhooks delivers queued events to the outbound webhooks until ctx is
+// cancelled.
+func (h *AuthzHandler) RunWebhooks(ctx context.Context) error {
+	if h.webhooks == nil {
+		return nil
+	}
+	var wg sync.WaitGroup
+	for u, queue := range h.webhooks.queues {
+		log.Printf("I: delivering events to %v", u)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-queue:
+					h.webhooks.deliver(ctx, u, delivery)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver posts a delivery to u, retrying network errors, 429 and 5xx
+// responses with exponential backoff up to WEBHOOK_MAX_ATTEMPTS times.
+func (w *webhooks) deliver(ctx context.Context, u string, delivery webhookDelivery) {
+	delay := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, u, delivery)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == WEBHOOK_MAX_ATTEMPTS {
+			log.Printf("E: [webhook] %s: giving up on %s event %s after %d attempts: %v", u, delivery.event, delivery.id, attempt, err)
+			return
+		}
+		log.Printf("W: [webhook] %s: attempt %d for %s event %s failed, retrying in %v: %v", u, attempt, delivery.event, delivery.id, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure may
+// be retried.
+func (w *webhooks) post(ctx context.Context, u string, delivery webhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, delivery.event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, delivery.id)
+	secret, err := os.ReadFile(w.secretPath)
+	if err != nil {
+		return true, fmt.Errorf("reading secret: %w", err)
+	}
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, signWebhook(bytes.TrimSpace(secret), delivery.body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// signWebhook returns the signature header value for body, in the format
+// GitHub uses: "sha256=" and the hex-encoded HMAC-SHA256.
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
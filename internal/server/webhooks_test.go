@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffNamespaces(t *testing.T) {
+	before := map[string]NamespaceConfig{
+		"cool-otter":       {Target: "blue", Source: "config.yaml"},
+		"awesome-penguin":  {Target: "red", Source: "config.yaml"},
+		"golden-retriever": {Target: "yellow", Description: "Golden Retriever"},
+	}
+	after := map[string]NamespaceConfig{
+		"cool-otter":       {Target: "green", Source: "config.yaml"},
+		"golden-retriever": {Target: "yellow", Description: "Retriever"},
+		"pr-1":             {Target: "pr-1", Source: NAMESPACE_SOURCE_ADMIN},
+	}
+	expected := ConfigDiff{
+		Generation: 7,
+		Added:      []NamespaceChange{{Namespace: "pr-1", Target: "pr-1", Source: NAMESPACE_SOURCE_ADMIN}},
+		Removed:    []NamespaceChange{{Namespace: "awesome-penguin", PreviousTarget: "red", Source: "config.yaml"}},
+		Retargeted: []NamespaceChange{{Namespace: "cool-otter", Target: "green", PreviousTarget: "blue", Source: "config.yaml"}},
+	}
+	if diff := diffNamespaces(before, after, 7); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diff)
+	}
+	if diff := diffNamespaces(before, before, 7); !diff.empty() {
+		t.Errorf("Expected no changes, got %+v", diff)
+	}
+}
+
+func TestConfigChangedEvents(t *testing.T) {
+	var lock sync.Mutex
+	var diffs []ConfigDiff
+	listener := func(e Event) {
+		if e.Type == EVENT_CONFIG_CHANGED {
+			lock.Lock()
+			defer lock.Unlock()
+			diffs = append(diffs, *e.Diff)
+		}
+	}
+	handler := newTestHandler(t, "namespaces:\n  cool-otter:\n    target: blue\n", WithEventListener(listener))
+	router := newAdminRouter(t, handler)
+	if len(diffs) != 0 {
+		t.Errorf("Expected no event for the initial load, got %+v", diffs)
+	}
+
+	if err := os.WriteFile(handler.configPath, []byte("namespaces:\n  cool-otter:\n    target: green\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := handler.loadConfig(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if err := handler.loadConfig(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	adminRequest(router, http.MethodPut, "/admin/namespaces/pr-1", "secret", `{"target": "pr-1"}`)
+	adminRequest(router, http.MethodDelete, "/admin/namespaces/pr-1", "secret", "")
+
+	expected := []ConfigDiff{
+		{Generation: 2, Retargeted: []NamespaceChange{{Namespace: "cool-otter", Target: "green", PreviousTarget: "blue", Source: "config.yaml"}}},
+		{Generation: 4, Added: []NamespaceChange{{Namespace: "pr-1", Target: "pr-1", Source: NAMESPACE_SOURCE_ADMIN}}},
+		{Generation: 5, Removed: []NamespaceChange{{Namespace: "pr-1", PreviousTarget: "pr-1", Source: NAMESPACE_SOURCE_ADMIN}}},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diffs)
+	}
+}
+
+// webhookReceiver records deliveries, failing the first ones with the given
+// statuses.
+type webhookReceiver struct {
+	lock       sync.Mutex
+	failures   []int
+	attempts   int
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.attempts++
+	if len(r.failures) > 0 {
+		status := r.failures[0]
+		r.failures = r.failures[1:]
+		w.WriteHeader(status)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	r.deliveries = append(r.deliveries, req)
+	r.bodies = append(r.bodies, body)
+}
+
+func (r *webhookReceiver) count() (int, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.attempts, len(r.deliveries)
+}
+
+// runWebhooks delivers events from handler until the test ends.
+func runWebhooks(t *testing.T, handler *AuthzHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.RunWebhooks(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitForAttempts(t *testing.T, receiver *webhookReceiver, attempts int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, _ := receiver.count(); got >= attempts {
+			return
+		}
+		if time.Now().After(deadline) {
+			got, _ := receiver.count()
+			t.Fatalf("Expected %d delivery attempts, got %d", attempts, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretPath, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	t.Run("signed and retried", func(t *testing.T) {
+		receiver := &webhookReceiver{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+		ts := httptest.NewServer(receiver)
+		defer ts.Close()
+
+		handler := newTestHandler(t, "namespaces:\n  cool-otter:\n    target: blue\n",
+			WithWebhooks(WebhookConfig{URLs: []string{ts.URL}, SecretPath: secretPath, Backoff: time.Millisecond}))
+		runWebhooks(t, handler)
+		router := newAdminRouter(t, handler)
+		adminRequest(router, http.MethodPut, "/admin/namespaces/pr-1", "secret", `{"target": "pr-1"}`)
+
+		waitForAttempts(t, receiver, 3)
+		if _, delivered := receiver.count(); delivered != 1 {
+			t.Fatalf("Expected 1 delivery, got %d", delivered)
+		}
+		req, body := receiver.deliveries[0], receiver.bodies[0]
+		if signature := req.Header.Get(WEBHOOK_SIGNATURE_HEADER); signature != signWebhook([]byte("s3cret"), body) {
+			t.Errorf("Expected a valid signature, got %q", signature)
+		}
+		if event := req.Header.Get(WEBHOOK_EVENT_HEADER); event != EVENT_CONFIG_CHANGED {
+			t.Errorf("Expected event header %s, got %q", EVENT_CONFIG_CHANGED, event)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil || event.Diff == nil || len(event.Diff.Added) != 1 || event.Diff.Added[0].Namespace != "pr-1" {
+			t.Errorf("Expected a diff adding pr-1, got %s", body)
+		}
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		receiver := &webhookReceiver{failures: []int{http.StatusBadRequest}}
+		ts := httptest.NewServer(receiver)
+		defer ts.Close()
+
+		handler := newTestHandler(t, "namespaces:\n  cool-otter:\n    target: blue\n",
+			WithWebhooks(WebhookConfig{URLs: []string{ts.URL}, SecretPath: secretPath, Backoff: time.Millisecond}))
+		runWebhooks(t, handler)
+		handler.emit(Event{Type: EVENT_NAMESPACE_EXPIRED, Namespace: "pr-1"})
+		handler.emit(Event{Type: EVENT_NAMESPACE_EXPIRED, Namespace: "pr-2"})
+
+		waitForAttempts(t, receiver, 2)
+		if _, delivered := receiver.count(); delivered != 1 {
+			t.Errorf("Expected the second event to be delivered after the first was rejected, got %d deliveries", delivered)
+		}
+	})
+}
+
+func TestNewWebhooks(t *testing.T) {
+	type testCase struct {
+		urls   []string
+		secret string
+		valid  bool
+	}
+	tests := []testCase{
+		{urls: splitURLs("https://chatops.example/hooks, http://dashboard:8080/events"), secret: "/secret", valid: true},
+		{urls: splitURLs("https://chatops.example/hooks")},
+		{urls: nil, secret: "/secret"},
+		{urls: []string{"chatops.example/hooks"}, secret: "/secret"},
+		{urls: []string{"ftp://chatops.example/hooks"}, secret: "/secret"},
+	}
+	for _, tc := range tests {
+		if _, err := newWebhooks(WebhookConfig{URLs: tc.urls, SecretPath: tc.secret}); (err == nil) != tc.valid {
+			t.Errorf("newWebhooks(%q, %q): expected valid %v, got %v", tc.urls, tc.secret, tc.valid, err)
+		}
+	}
+}